@@ -0,0 +1,378 @@
+package smuggol
+
+import (
+	"context"
+	"fmt"
+	"go/build"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// _apiChange is a single change to the exported API of a package, between the version
+// currently smuggled and the incoming version. Key identifies what changed: "Name" for a
+// package-level identifier, "Type.Name" for a method or struct field
+type _apiChange struct {
+	Key      string
+	Message  string
+	Breaking bool
+	Uses     []token.Position // Where the host package uses what changed (if breaking)
+}
+
+func (self _apiChange) String() string {
+	if self.Breaking {
+		return "! " + self.Message
+	}
+	return "+ " + self.Message
+}
+
+func apiQualifier(pkg *types.Package) types.Qualifier {
+	return func(other *types.Package) string {
+		if other == pkg {
+			return ""
+		}
+		return other.Name()
+	}
+}
+
+func apiKind(object types.Object) string {
+	switch object.(type) {
+	case *types.Func:
+		return "func"
+	case *types.TypeName:
+		return "type"
+	case *types.Var:
+		return "var"
+	case *types.Const:
+		return "const"
+	}
+	return "?"
+}
+
+// apiDiff compares the exported API of before with after, in the style of apidiff:
+// removing or changing anything (even the value of a const) is breaking, while adding
+// something is compatible (except adding a method to an interface, which breaks implementations)
+func apiDiff(before, after *types.Package) []_apiChange {
+	result := []_apiChange{}
+	add := func(key string, breaking bool, format string, arguments ...interface{}) {
+		result = append(result, _apiChange{
+			Key:      key,
+			Message:  fmt.Sprintf(format, arguments...),
+			Breaking: breaking,
+		})
+	}
+	beforeQualifier, afterQualifier := apiQualifier(before), apiQualifier(after)
+	typeString := func(typ types.Type, qualifier types.Qualifier) string {
+		return types.TypeString(typ, qualifier)
+	}
+
+	for _, name := range before.Scope().Names() {
+		old := before.Scope().Lookup(name)
+		if !old.Exported() {
+			continue
+		}
+		kind := apiKind(old)
+		next := after.Scope().Lookup(name)
+		if next == nil || !next.Exported() {
+			add(name, true, "removed: %s %s", kind, name)
+			continue
+		}
+		if apiKind(next) != kind {
+			add(name, true, "changed: %s %s is now a %s", kind, name, apiKind(next))
+			continue
+		}
+
+		switch old := old.(type) {
+		case *types.Const:
+			next := next.(*types.Const)
+			if x, y := typeString(old.Type(), beforeQualifier), typeString(next.Type(), afterQualifier); x != y {
+				add(name, true, "changed: const %s: %s => %s", name, x, y)
+			} else if old.Val().ExactString() != next.Val().ExactString() {
+				add(name, true, "changed: const %s: value %s => %s", name, old.Val().ExactString(), next.Val().ExactString())
+			}
+		case *types.Func, *types.Var:
+			if x, y := typeString(old.Type(), beforeQualifier), typeString(next.Type(), afterQualifier); x != y {
+				add(name, true, "changed: %s %s: %s => %s", kind, name, x, y)
+			}
+		case *types.TypeName:
+			result = append(result, apiDiffType(name, old.Type(), next.Type(), beforeQualifier, afterQualifier)...)
+		}
+	}
+
+	for _, name := range after.Scope().Names() {
+		next := after.Scope().Lookup(name)
+		if !next.Exported() {
+			continue
+		}
+		if old := before.Scope().Lookup(name); old == nil || !old.Exported() {
+			add(name, false, "added: %s %s", apiKind(next), name)
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Breaking != result[j].Breaking {
+			return result[i].Breaking
+		}
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+// apiDiffType compares two versions of the (exported) type name: struct fields, interface
+// methods, the method set, or else the underlying type itself
+func apiDiffType(name string, before, after types.Type, beforeQualifier, afterQualifier types.Qualifier) []_apiChange {
+	result := []_apiChange{}
+	add := func(key string, breaking bool, format string, arguments ...interface{}) {
+		result = append(result, _apiChange{
+			Key:      key,
+			Message:  fmt.Sprintf(format, arguments...),
+			Breaking: breaking,
+		})
+	}
+
+	switch old := before.Underlying().(type) {
+	case *types.Struct:
+		next, ok := after.Underlying().(*types.Struct)
+		if !ok {
+			add(name, true, "changed: type %s is no longer a struct", name)
+			return result
+		}
+		fields := map[string]*types.Var{}
+		for index := 0; index < next.NumFields(); index++ {
+			fields[next.Field(index).Name()] = next.Field(index)
+		}
+		for index := 0; index < old.NumFields(); index++ {
+			field := old.Field(index)
+			if !field.Exported() {
+				continue
+			}
+			key := name + "." + field.Name()
+			other := fields[field.Name()]
+			if other == nil || !other.Exported() {
+				add(key, true, "removed: field %s", key)
+				continue
+			}
+			if x, y := types.TypeString(field.Type(), beforeQualifier), types.TypeString(other.Type(), afterQualifier); x != y {
+				add(key, true, "changed: field %s: %s => %s", key, x, y)
+			}
+		}
+		for index := 0; index < next.NumFields(); index++ {
+			field := next.Field(index)
+			if !field.Exported() {
+				continue
+			}
+			exists := false
+			for index := 0; index < old.NumFields(); index++ {
+				if old.Field(index).Name() == field.Name() {
+					exists = true
+				}
+			}
+			if !exists {
+				add(name+"."+field.Name(), false, "added: field %s.%s", name, field.Name())
+			}
+		}
+
+	case *types.Interface:
+		next, ok := after.Underlying().(*types.Interface)
+		if !ok {
+			add(name, true, "changed: type %s is no longer an interface", name)
+			return result
+		}
+		methods := map[string]*types.Func{}
+		for index := 0; index < next.NumMethods(); index++ {
+			methods[next.Method(index).Name()] = next.Method(index)
+		}
+		for index := 0; index < old.NumMethods(); index++ {
+			method := old.Method(index)
+			key := name + "." + method.Name()
+			other := methods[method.Name()]
+			delete(methods, method.Name())
+			if other == nil {
+				add(key, true, "removed: method %s", key)
+				continue
+			}
+			if x, y := types.TypeString(method.Type(), beforeQualifier), types.TypeString(other.Type(), afterQualifier); x != y {
+				add(key, true, "changed: method %s: %s => %s", key, x, y)
+			}
+		}
+		names := []string{}
+		for method := range methods {
+			if token.IsExported(method) {
+				names = append(names, method)
+			}
+		}
+		sort.Strings(names)
+		for _, method := range names {
+			add(name, true, "added: method %s.%s (breaks implementations)", name, method)
+		}
+		return result
+
+	default:
+		if x, y := types.TypeString(before.Underlying(), beforeQualifier), types.TypeString(after.Underlying(), afterQualifier); x != y {
+			add(name, true, "changed: type %s: %s => %s", name, x, y)
+			return result
+		}
+	}
+
+	// The method set (of *T, which includes that of T)
+	methodSet := func(typ types.Type) map[string]*types.Func {
+		result := map[string]*types.Func{}
+		set := types.NewMethodSet(types.NewPointer(typ))
+		for index := 0; index < set.Len(); index++ {
+			if method, ok := set.At(index).Obj().(*types.Func); ok && method.Exported() {
+				result[method.Name()] = method
+			}
+		}
+		return result
+	}
+	oldMethods, newMethods := methodSet(before), methodSet(after)
+	names := []string{}
+	for method := range oldMethods {
+		names = append(names, method)
+	}
+	sort.Strings(names)
+	for _, method := range names {
+		key := name + "." + method
+		other := newMethods[method]
+		if other == nil {
+			add(key, true, "removed: method %s", key)
+			continue
+		}
+		if x, y := types.TypeString(oldMethods[method].Type(), beforeQualifier), types.TypeString(other.Type(), afterQualifier); x != y {
+			add(key, true, "changed: method %s: %s => %s", key, x, y)
+		}
+	}
+	names = names[:0]
+	for method := range newMethods {
+		if oldMethods[method] == nil {
+			names = append(names, method)
+		}
+	}
+	sort.Strings(names)
+	for _, method := range names {
+		add(name+"."+method, false, "added: method %s.%s", name, method)
+	}
+	return result
+}
+
+// apiUses finds where the host package uses the package at importPath: each use is
+// keyed the same way as an _apiChange ("Name", or "Type.Name" for a method or field)
+func apiUses(host *_checked, importPath string) map[string][]token.Position {
+	result := map[string][]token.Position{}
+	for ident, object := range host.info.Uses {
+		if object.Pkg() == nil || object.Pkg().Path() != importPath {
+			continue
+		}
+		key := pruneKey(object)
+		if key == "" {
+			continue
+		}
+		result[key] = append(result[key], host.fileSet.Position(ident.Pos()))
+	}
+	for expression, selection := range host.info.Selections {
+		if selection.Kind() != types.FieldVal || selection.Obj().Pkg() == nil || selection.Obj().Pkg().Path() != importPath {
+			continue
+		}
+		recv := selection.Recv()
+		if pointer, ok := recv.(*types.Pointer); ok {
+			recv = pointer.Elem()
+		}
+		if named, ok := recv.(*types.Named); ok {
+			key := named.Obj().Name() + "." + selection.Obj().Name()
+			result[key] = append(result[key], host.fileSet.Position(expression.Sel.Pos()))
+		}
+	}
+	for key := range result {
+		positions := result[key]
+		sort.Slice(positions, func(i, j int) bool {
+			if positions[i].Filename != positions[j].Filename {
+				return positions[i].Filename < positions[j].Filename
+			}
+			return positions[i].Offset < positions[j].Offset
+		})
+	}
+	return result
+}
+
+// apiReport cross-references changes with uses, prints a report (or, with -json, an apidiff
+// event for each change) to output, and returns the number of breaking changes that affect the host
+func apiReport(output *_output, title string, changes []_apiChange, uses map[string][]token.Position) int {
+	affected := 0
+	output.say("# apidiff %s", title)
+	if len(changes) == 0 {
+		output.say("    no changes")
+		output.emit(_event{Action: "apidiff", Package: title})
+	}
+	for index := range changes {
+		change := &changes[index]
+		if change.Breaking {
+			change.Uses = uses[change.Key]
+			// A breaking change to a type (e.g. a new interface method) affects any use of the type
+			if !strings.Contains(change.Key, ".") {
+				for key, positions := range uses {
+					if strings.HasPrefix(key, change.Key+".") {
+						change.Uses = append(change.Uses, positions...)
+					}
+				}
+			}
+			if len(change.Uses) > 0 {
+				affected++
+			}
+		}
+		output.say("%s", change)
+		positions := []string{}
+		for _, position := range change.Uses {
+			output.say("    %s", position)
+			positions = append(positions, position.String())
+		}
+		output.emit(_event{Action: "apidiff", Package: title, Change: change.String(), Uses: positions})
+	}
+	return affected
+}
+
+// apiDiffImport compares the package currently smuggled into dstPath with the incoming
+// package (srcPkg), reporting (to the output of ctx) every change and which host lines a breaking
+// change affects. Nothing is written. It fails if any breaking change affects the host
+func apiDiffImport(ctx context.Context, title, dstPath string, srcPkg, dstPkg *build.Package, host bool) error {
+	output := outputOf(ctx)
+	currentPkg, err := buildImport(dstPath)
+	if err != nil || len(currentPkg.GoFiles) == 0 {
+		output.say("# apidiff %s: nothing to compare with (in %s)", title, dstPath)
+		output.emit(_event{Action: "apidiff", Package: title, Path: dstPath})
+		return nil
+	}
+	importPath := currentPkg.ImportPath
+	if importPath == "." {
+		// import "./<currentPkg.Name>"
+		importPath = "." + string(filepath.Separator) + currentPkg.Name
+	}
+	current, err := typeCheck(importPath, dstPath, currentPkg.GoFiles)
+	if err != nil {
+		return err
+	}
+	incoming, err := typeCheck(srcPkg.ImportPath, srcPkg.Dir, srcPkg.GoFiles)
+	if err != nil {
+		return err
+	}
+	if current.pkg == nil || incoming.pkg == nil {
+		return fmt.Errorf("apidiff: unable to type-check")
+	}
+
+	uses := map[string][]token.Position{}
+	if host {
+		checked, err := typeCheck(dstPkg.ImportPath, dstPkg.Dir, append(append([]string{}, dstPkg.GoFiles...), dstPkg.TestGoFiles...))
+		if err != nil {
+			return err
+		}
+		uses = apiUses(checked, importPath)
+	}
+
+	changes := apiDiff(current.pkg, incoming.pkg)
+	affected := apiReport(output, title, changes, uses)
+	if affected > 0 {
+		return fail(ErrPolicy, fmt.Errorf("apidiff: %d breaking change(s) affect the host package", affected))
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package smuggol
+
+import (
+	"encoding/json"
+	"errors"
+	. "github.com/robertkrimen/smuggol/terst"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApiDiff(t *testing.T) {
+	Terst(t)
+
+	base, err := ioutil.TempDir("", "smuggol.")
+	Is(err, nil)
+	if err != nil {
+		FailNow()
+	}
+	defer os.RemoveAll(base)
+
+	check := func(name, source string) *_checked {
+		dir := filepath.Join(base, name)
+		os.MkdirAll(dir, 0777)
+		ioutil.WriteFile(filepath.Join(dir, "xyzzy.go"), []byte(source), 0666)
+		checked, _ := typeCheck("example.com/xyzzy", dir, []string{"xyzzy.go"})
+		return checked
+	}
+
+	before := check("before", kiltGraveTrim(`
+package xyzzy
+
+const Version = 1
+
+func Removed() {}
+
+func Changed(int) string { return "" }
+
+type Thing struct {
+	Name  string
+	Count int
+}
+
+func (Thing) Method() {}
+
+type Doer interface {
+	Do()
+}
+    `))
+
+	after := check("after", kiltGraveTrim(`
+package xyzzy
+
+const Version = 2
+
+func Changed(string) string { return "" }
+
+func Added() {}
+
+type Thing struct {
+	Name  string
+	Extra bool
+}
+
+type Doer interface {
+	Undo()
+	Do()
+	Redo()
+}
+    `))
+
+	changes := apiDiff(before.pkg, after.pkg)
+	messages := []string{}
+	for _, change := range changes {
+		messages = append(messages, change.String())
+	}
+	Is(strings.Join(messages, "\n"), strings.Join([]string{
+		"! changed: func Changed: func(int) string => func(string) string",
+		"! added: method Doer.Redo (breaks implementations)",
+		"! added: method Doer.Undo (breaks implementations)",
+		"! removed: func Removed",
+		"! removed: field Thing.Count",
+		"! removed: method Thing.Method",
+		"! changed: const Version: value 1 => 2",
+		"+ added: func Added",
+		"+ added: field Thing.Extra",
+	}, "\n"))
+
+	// A host package (outside of GOPATH, so it imports "./xyzzy"), with before smuggled into xyzzy
+	dir := filepath.Join(base, "host")
+	os.MkdirAll(dir, 0777)
+	ioutil.WriteFile(filepath.Join(dir, "host.go"), []byte(kiltGraveTrim(`
+package host
+
+import "./xyzzy"
+
+var version = xyzzy.Version
+
+func use(thing xyzzy.Thing, doer xyzzy.Doer) int {
+	xyzzy.Removed()
+	doer.Do()
+	return thing.Count
+}
+    `)), 0666)
+	flag_quiet = true
+	defer func() {
+		flag_quiet, flag_apidiff, flag_json = false, false, false
+	}()
+	mainName = "xyzzy-import"
+	Is(main(dir, filepath.Join(base, "before"), nil), nil)
+	content, _ := ioutil.ReadFile(filepath.Join(dir, "xyzzy", "xyzzy.go"))
+
+	// capture returns what fn prints on standard output
+	capture := func(fn func()) string {
+		file, _ := ioutil.TempFile(base, "stdout.")
+		stdout := os.Stdout
+		os.Stdout = file
+		fn()
+		os.Stdout = stdout
+		file.Close()
+		content, _ := ioutil.ReadFile(file.Name())
+		return string(content)
+	}
+
+	// With -apidiff, after is compared with what is in xyzzy, and nothing is written
+	flag_quiet, flag_apidiff = false, true
+	report := capture(func() {
+		err = main(dir, filepath.Join(base, "after"), nil)
+	})
+	Like(err, `apidiff: 5 breaking change\(s\) affect the host package`)
+	Is(errors.Is(err, ErrPolicy), true)
+	current, _ := ioutil.ReadFile(filepath.Join(dir, "xyzzy", "xyzzy.go"))
+	Is(string(current), string(content))
+	Like(report, `(?m)^# apidiff .*after\n! changed: func Changed: .*\n! added: method Doer\.Redo \(breaks implementations\)\n    .*host\.go:7:40\n    .*host\.go:9:7\n! added: method Doer\.Undo \(breaks implementations\)\n    .*host\.go:7:40\n    .*host\.go:9:7\n`)
+	Like(report, `(?m)^! removed: func Removed\n    .*host\.go:8:8\n! removed: field Thing\.Count\n    .*host\.go:10:15\n`)
+	Like(report, `(?m)^! changed: const Version: value 1 => 2\n    .*host\.go:5:21\n\+ added: func Added\n`)
+
+	// ...or, with -json, an event for each change
+	flag_json = true
+	report = capture(func() {
+		err = main(dir, filepath.Join(base, "after"), nil)
+	})
+	Is(errors.Is(err, ErrPolicy), true)
+	events := []_event{}
+	for _, line := range strings.Split(strings.TrimSpace(report), "\n") {
+		event := _event{}
+		Is(json.Unmarshal([]byte(line), &event), nil)
+		if event.Action == "apidiff" {
+			events = append(events, event)
+		}
+	}
+	Is(len(events), 9)
+	if len(events) == 9 {
+		Is(events[2].Change, "! added: method Doer.Undo (breaks implementations)")
+		Is(len(events[2].Uses), 2)
+		Like(events[2].Uses[1], `host\.go:9:7$`)
+	}
+}
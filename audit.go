@@ -0,0 +1,280 @@
+package smuggol
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// _audit is a group of generated files in a single directory that share an origin and a tool
+type _audit struct {
+	Dir      string   `json:"dir"`
+	Kind     string   `json:"kind"`               // "import" (copied files) or "extra" (generated for the host package)
+	Source   string   `json:"source"`             // The import path (from the header)
+	Version  string   `json:"version,omitempty"`  // The version (from the header)
+	Revision string   `json:"revision,omitempty"` // The revision (from the header)
+	Tool     string   `json:"tool"`               // The tool that generated the files
+	Locked   bool     `json:"locked"`             // Whether there is a matching smuggol.lock entry
+	Licenses []string `json:"licenses,omitempty"` // The licenses (from the lock entry)
+	Files    []string `json:"files,omitempty"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+func (self _audit) origin() string {
+	return _header{source: self.Source, version: self.Version, revision: shortRevision(self.Revision)}.origin()
+}
+
+// _auditFile is a generated file found by the walk
+type _auditFile struct {
+	dir, name string
+	header    _header
+}
+
+// auditWalk finds every generated .go file and every lockfile under root
+func auditWalk(root string) ([]_auditFile, map[string]*_lock, error) {
+	files := []_auditFile{}
+	locks := map[string]*_lock{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != root && skipDir(info.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		dir, name := filepath.Split(path)
+		dir = filepath.Clean(dir)
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			// From -link (see the lock entry)
+		case name == lockName:
+			lock, err := readLock(dir)
+			if err != nil {
+				return err
+			}
+			locks[dir] = lock
+		case strings.HasSuffix(name, ".go"):
+			head, err := readHead(path, 512)
+			if err != nil {
+				return err
+			}
+			if header, ok := parseHeader(head); ok {
+				files = append(files, _auditFile{dir: dir, name: name, header: header})
+			}
+		}
+		return nil
+	})
+	return files, locks, err
+}
+
+// audit takes an inventory of the smuggled code under root, checking it against the lockfiles
+func audit(root string) ([]_audit, error) {
+	files, locks, err := auditWalk(root)
+	if err != nil {
+		return nil, err
+	}
+
+	type key struct {
+		dir, kind string
+		header    _header
+	}
+	groups := map[key]*_audit{}
+	order := []key{}
+	for _, file := range files {
+		kind := "import"
+		if file.header.kind == "for" {
+			kind = "extra"
+		}
+		key := key{dir: file.dir, kind: kind, header: file.header}
+		group, exists := groups[key]
+		if !exists {
+			group = &_audit{
+				Dir:      file.dir,
+				Kind:     kind,
+				Source:   file.header.source,
+				Version:  file.header.version,
+				Revision: file.header.revision,
+				Tool:     file.header.tool,
+			}
+			groups[key] = group
+			order = append(order, key)
+		}
+		group.Files = append(group.Files, file.name)
+	}
+
+	// An import package with files from more than one origin was only partly updated
+	origins := map[string][]string{}
+	for _, key := range order {
+		if key.kind == "import" {
+			origins[key.dir] = append(origins[key.dir], groups[key].origin())
+		}
+	}
+
+	seen := map[string]bool{} // Lock entries (by directory) that have generated files
+	for _, key := range order {
+		group := groups[key]
+		if key.kind == "import" && len(origins[key.dir]) > 1 {
+			group.Problems = append(group.Problems, fmt.Sprintf("half-updated: mixed origins (%s)", strings.Join(origins[key.dir], ", ")))
+		}
+
+		// The lockfile lives in the host package, which is the parent of an import package
+		hostDir := key.dir
+		if key.kind == "import" {
+			hostDir = filepath.Dir(key.dir)
+		}
+		lock := locks[hostDir]
+		entry := _lockImport{}
+		if lock != nil {
+			for _, candidate := range lock.Imports {
+				if key.kind == "import" && candidate.Name == filepath.Base(key.dir) ||
+					key.kind == "extra" && candidate.Source == group.Source {
+					entry = candidate
+					break
+				}
+			}
+		}
+		if entry.Source == "" {
+			group.Problems = append(group.Problems, fmt.Sprintf("orphaned: no entry in %s", filepath.Join(hostDir, lockName)))
+			continue
+		}
+		group.Locked = true
+		group.Licenses = licenseSummary(entry.Licenses)
+		if entry.Source != group.Source || entry.Version != group.Version ||
+			group.Revision != "" && entry.Revision != group.Revision {
+			locked := _header{source: entry.Source, version: entry.Version, revision: shortRevision(entry.Revision)}.origin()
+			group.Problems = append(group.Problems, fmt.Sprintf("half-updated: header has %s, lock has %s", group.origin(), locked))
+		}
+		if key.kind != "import" {
+			continue
+		}
+		seen[key.dir] = true
+		for _, name := range group.Files {
+			sum, exists := entry.Files[name]
+			if !exists {
+				group.Problems = append(group.Problems, fmt.Sprintf("untracked: %s", name))
+				continue
+			}
+			content, err := ioutil.ReadFile(filepath.Join(key.dir, name))
+			if err != nil {
+				return nil, err
+			}
+			if kilt.Sha1(content) != sum {
+				group.Problems = append(group.Problems, fmt.Sprintf("modified: %s", name))
+			}
+		}
+	}
+
+	result := []_audit{}
+	for _, key := range order {
+		result = append(result, *groups[key])
+	}
+
+	// Lock entries whose files are gone (or were never generated)
+	for dir, lock := range locks {
+		for _, entry := range lock.Imports {
+			importDir := filepath.Join(dir, entry.Name)
+			if len(entry.Files) == 0 || seen[importDir] {
+				continue
+			}
+			problem := "missing: no generated files"
+			if entry.Link != "" {
+				problem = fmt.Sprintf("linked: to %s (materialize before committing)", entry.Link)
+			}
+			result = append(result, _audit{
+				Dir:      importDir,
+				Kind:     "import",
+				Source:   entry.Source,
+				Version:  entry.Version,
+				Revision: entry.Revision,
+				Tool:     entry.Tool,
+				Locked:   true,
+				Licenses: licenseSummary(entry.Licenses),
+				Problems: []string{problem},
+			})
+		}
+	}
+
+	// Files in the lock, but not on disk (reported once per directory)
+	for index := range result {
+		group := &result[index]
+		if group.Kind != "import" || !group.Locked || !seen[group.Dir] {
+			continue
+		}
+		seen[group.Dir] = false
+		entry := locks[filepath.Dir(group.Dir)].get(filepath.Base(group.Dir))
+		for name := range entry.Files {
+			if _, err := os.Stat(filepath.Join(group.Dir, name)); os.IsNotExist(err) {
+				group.Problems = append(group.Problems, fmt.Sprintf("missing: %s", name))
+			}
+		}
+	}
+	for _, group := range result {
+		sort.Strings(group.Problems)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Dir != result[j].Dir {
+			return result[i].Dir < result[j].Dir
+		}
+		return result[i].Kind > result[j].Kind
+	})
+	return result, nil
+}
+
+// mainAudit reports every package of smuggled code under root, as a table (or as JSON, with -json).
+// It fails if there is any problem.
+func mainAudit(root string, output io.Writer) error {
+	if root == "" {
+		root = "."
+	}
+	result, err := audit(root)
+	if err != nil {
+		return err
+	}
+
+	problems := 0
+	for _, group := range result {
+		problems += len(group.Problems)
+	}
+
+	if flag_json {
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "    ")
+		err := encoder.Encode(result)
+		if err != nil {
+			return err
+		}
+	} else {
+		table := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(table, "DIR\tKIND\tORIGIN\tTOOL\tLICENSE\tFILES\n")
+		for _, group := range result {
+			licenses := strings.Join(group.Licenses, ", ")
+			if licenses == "" {
+				licenses = "-"
+			}
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%d\n", group.Dir, group.Kind, group.origin(), group.Tool, licenses, len(group.Files))
+		}
+		err := table.Flush()
+		if err != nil {
+			return err
+		}
+		for _, group := range result {
+			for _, problem := range group.Problems {
+				fmt.Fprintf(output, "%s: %s\n", group.Dir, problem)
+			}
+		}
+	}
+
+	if problems > 0 {
+		return fail(ErrDrift, fmt.Errorf("audit: %d problem(s)", problems))
+	}
+	return nil
+}
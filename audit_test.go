@@ -0,0 +1,114 @@
+package smuggol
+
+import (
+	. "github.com/robertkrimen/smuggol/terst"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAudit(t *testing.T) {
+	Terst(t)
+
+	header, ok := parseHeader([]byte("// Code generated by xyzzy-import (smuggol) from xyzzy@v1.0.0 (3ff95df6033f). DO NOT EDIT.\n\npackage xyzzy\n"))
+	Is(ok, true)
+	Is(header.tool, "xyzzy-import")
+	Is(header.kind, "from")
+	Is(header.source, "xyzzy")
+	Is(header.version, "v1.0.0")
+	Is(header.revision, "3ff95df6033f")
+	Is(header.String(), "// Code generated by xyzzy-import (smuggol) from xyzzy@v1.0.0 (3ff95df6033f). DO NOT EDIT.")
+	Like(header.String(), `^// Code generated .* DO NOT EDIT\.$`)
+
+	header, ok = parseHeader([]byte("// Code generated by xyzzy-import (smuggol) for example.com/xyzzy@v1.0.0. DO NOT EDIT.\n"))
+	Is(ok, true)
+	Is(header.kind, "for")
+	Is(header.source, "example.com/xyzzy")
+	Is(header.version, "v1.0.0")
+	Is(header.revision, "")
+
+	header, ok = parseHeader([]byte("// Code generated by xyzzy-import (smuggol) from example.com/xyzzy. DO NOT EDIT.\n"))
+	Is(ok, true)
+	Is(header.source, "example.com/xyzzy")
+	Is(header.version, "")
+
+	header, ok = parseHeader([]byte("// This file was AUTOMATICALLY GENERATED by xyzzy-import (smuggol) from xyzzy@v1.0.0 (3ff95df6033f)\n\npackage xyzzy\n"))
+	Is(ok, true)
+	Is(header.tool, "xyzzy-import")
+	Is(header.version, "v1.0.0")
+	Is(header.revision, "3ff95df6033f")
+	Is(isGenerated([]byte("// This file was AUTOMATICALLY GENERATED by xyzzy-import (smuggol) from xyzzy\n")), true)
+	Is(isGenerated([]byte("// Code generated by stringer. DO NOT EDIT.\n")), false)
+
+	_, ok = parseHeader([]byte("package xyzzy\n"))
+	Is(ok, false)
+
+	base, err := ioutil.TempDir("", "smuggol.")
+	Is(err, nil)
+	if err != nil {
+		FailNow()
+	}
+	defer os.RemoveAll(base)
+
+	write := func(path, content string) {
+		path = filepath.Join(base, path)
+		os.MkdirAll(filepath.Dir(path), 0777)
+		ioutil.WriteFile(path, []byte(content), 0666)
+	}
+	from := "// Code generated by xyzzy-import (smuggol) from xyzzy@v1.0.0. DO NOT EDIT.\n\npackage xyzzy\n"
+	write("host/xyzzy/xyzzy.go", from)
+	write("host/xyzzy/modified.go", from+"// modified\n")
+	write("host/xyzzy/untracked.go", from)
+	write("host/xyzzy/stale.go", "// This file was AUTOMATICALLY GENERATED by xyzzy-import (smuggol) from xyzzy@v0.9.0\n\npackage xyzzy\n")
+	write("host/xyzzy.go", "// Code generated by xyzzy-import (smuggol) for xyzzy@v1.0.0. DO NOT EDIT.\n\npackage host\n")
+	write("host/main.go", "package host\n")
+	write("orphan/nothing/nothing.go", "// This file was AUTOMATICALLY GENERATED by nothing-import (smuggol) from nothing\n\npackage nothing\n")
+
+	lock := &_lock{}
+	lock.set(_lockImport{
+		Name:    "xyzzy",
+		Source:  "xyzzy",
+		Version: "v1.0.0",
+		Tool:    "xyzzy-import",
+		Files: map[string]string{
+			"xyzzy.go":    kilt.Sha1([]byte(from)),
+			"modified.go": kilt.Sha1([]byte(from)),
+			"stale.go":    kilt.Sha1([]byte(from)),
+			"missing.go":  kilt.Sha1([]byte(from)),
+		},
+	})
+	Is(lock.write(filepath.Join(base, "host")), nil)
+
+	result, err := audit(base)
+	Is(err, nil)
+	Is(len(result), 4)
+	if len(result) == 4 {
+		Is(result[0].Dir, filepath.Join(base, "host"))
+		Is(result[0].Kind, "extra")
+		Is(result[0].Locked, true)
+		Is(len(result[0].Problems), 0)
+
+		Is(result[1].Dir, filepath.Join(base, "host", "xyzzy"))
+		Is(result[1].Kind, "import")
+		Is(result[1].origin(), "xyzzy@v1.0.0")
+		Is(result[1].Files, []string{"modified.go", "untracked.go", "xyzzy.go"})
+		Is(result[1].Problems, []string{
+			"half-updated: mixed origins (xyzzy@v1.0.0, xyzzy@v0.9.0)",
+			"missing: missing.go",
+			"modified: modified.go",
+			"untracked: untracked.go",
+		})
+
+		Is(result[2].origin(), "xyzzy@v0.9.0")
+		Is(result[2].Problems, []string{
+			"half-updated: header has xyzzy@v0.9.0, lock has xyzzy@v1.0.0",
+			"half-updated: mixed origins (xyzzy@v1.0.0, xyzzy@v0.9.0)",
+			"modified: stale.go",
+		})
+
+		Is(result[3].Dir, filepath.Join(base, "orphan", "nothing"))
+		Is(result[3].Locked, false)
+		Is(len(result[3].Problems), 1)
+	}
+}
@@ -0,0 +1,178 @@
+package smuggol
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+)
+
+// Concurrent imports (see runDirectives) go through two gates: claim, before writing into the
+// subordinate package, and wait, before touching the host package. The first is passed
+// concurrently, and the second in order
+
+// _turn is the place of an import among concurrent imports (see runDirectives)
+type _turn struct {
+	slots   chan struct{} // Shared by every import: a slot is held while working (see -jobs)
+	held    bool
+	claims  chan string   // Where the import is about to write (its subordinate package)
+	verdict chan bool     // Whether to go ahead and write
+	ready   chan struct{} // Closed when the import is waiting for its turn
+	start   chan struct{} // Closed when it is the turn of the import
+	done    chan error    // What the import returned
+}
+
+func newTurn(slots chan struct{}) *_turn {
+	return &_turn{
+		slots:   slots,
+		claims:  make(chan string, 1),
+		verdict: make(chan bool, 1),
+		ready:   make(chan struct{}),
+		start:   make(chan struct{}),
+		done:    make(chan error, 1),
+	}
+}
+
+// acquire waits for a slot (or for ctx to be cancelled)
+func (self *_turn) acquire(ctx context.Context) error {
+	select {
+	case self.slots <- struct{}{}:
+		self.held = true
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release gives up the slot, if held
+func (self *_turn) release() {
+	if self.held {
+		<-self.slots
+		self.held = false
+	}
+}
+
+// claim says where the import is about to write (path), and waits to be told to go ahead:
+// not if an earlier import failed, or is writing to path as well
+func (self *_turn) claim(ctx context.Context, path string) error {
+	if self == nil {
+		return nil
+	}
+	self.release()
+	self.claims <- path
+	if !<-self.verdict {
+		return context.Canceled
+	}
+	return self.acquire(ctx)
+}
+
+// wait waits until it is the turn of the import (every earlier import is done), or until ctx
+// is cancelled (an earlier import failed)
+func (self *_turn) wait(ctx context.Context) error {
+	if self == nil {
+		return nil
+	}
+	self.release()
+	close(self.ready)
+	select {
+	case <-self.start:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runDirectives performs the imports of directives (in the host package at dst) concurrently,
+// with at most jobs at work at once, as if one after another
+func runDirectives(dst string, directives []directive, extra interface{}, jobs int) error {
+	slots := make(chan struct{}, jobs)
+	turns := make([]*_turn, len(directives))
+	outputs := make([]*_output, len(directives))
+	cancels := make([]context.CancelFunc, len(directives))
+	for index, directive := range directives {
+		turn, output := newTurn(slots), &_output{buffer: true}
+		ctx, cancel := context.WithCancel(withOutput(context.Background(), output))
+		turns[index], outputs[index], cancels[index] = turn, output, cancel
+		defer cancel()
+
+		if flag_verbose {
+			output.say("# %s (%s)", directive, directive.position)
+		}
+		import_ := directive.import_(dst, extra)
+		import_.turn = turn
+		go func() {
+			err := turn.acquire(ctx)
+			if err == nil {
+				err = import_.run(ctx)
+			}
+			turn.release()
+			turn.done <- err
+		}()
+	}
+
+	// stop cancels every import after index, and waits for each to finish (undone)
+	finished := make([]bool, len(directives))
+	stop := func(index int) {
+		for _, cancel := range cancels[index+1:] {
+			cancel()
+		}
+		for later := index + 1; later < len(turns); later++ {
+			if !finished[later] {
+				<-turns[later].done
+			}
+		}
+	}
+
+	// In order, each import either claims its subordinate package or is done (having failed, or
+	// with nothing to write). Nothing is written after a failure, or into the same directory twice
+	errs := make([]error, len(directives))
+	failed := -1
+	claimed := map[string]int{}
+	for index, turn := range turns {
+		select {
+		case path := <-turn.claims:
+			if other, exists := claimed[path]; exists && failed < 0 {
+				failed = index
+				errs[index] = fail(ErrConflict, fmt.Errorf("conflict: %s is already imported as %s (by %s)", directives[index].path, filepath.Base(path), directives[other].position))
+			}
+			if failed >= 0 {
+				turn.verdict <- false
+				continue
+			}
+			claimed[path] = index
+			turn.verdict <- true
+		case err := <-turn.done:
+			finished[index], errs[index] = true, err
+			if err != nil && failed < 0 {
+				failed = index
+			}
+		}
+		if failed >= 0 {
+			for _, cancel := range cancels[failed+1:] {
+				cancel()
+			}
+		}
+	}
+
+	// Then, in order, each import takes its turn at the host package
+	for index, turn := range turns {
+		err := errs[index]
+		if !finished[index] {
+			select {
+			case <-turn.ready:
+				close(turn.start)
+				err = <-turn.done
+			case done := <-turn.done:
+				if err == nil {
+					err = done
+				}
+			}
+			finished[index] = true
+		}
+		outputs[index].flush()
+		if err != nil {
+			stop(index)
+			return fmt.Errorf("%s: %w", directives[index].position, err)
+		}
+	}
+	return nil
+}
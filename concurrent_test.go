@@ -0,0 +1,134 @@
+package smuggol
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	. "github.com/robertkrimen/smuggol/terst"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConcurrent(t *testing.T) {
+	Terst(t)
+
+	base, err := ioutil.TempDir("", "smuggol.")
+	Is(err, nil)
+	if err != nil {
+		FailNow()
+	}
+	defer os.RemoveAll(base)
+
+	// A proxy (on disk) with a few modules, two of which are both package xyzzy
+	for module, name := range map[string]string{
+		"example.com/alpha":   "alpha",
+		"example.com/bravo":   "bravo",
+		"example.com/charlie": "charlie",
+		"example.com/xyzzy":   "xyzzy",
+		"example.com/plugh":   "xyzzy",
+	} {
+		var buffer bytes.Buffer
+		writer := zip.NewWriter(&buffer)
+		file, _ := writer.Create(module + "@v1.0.0/" + name + ".go")
+		file.Write([]byte("package " + name + "\n\nconst Name = \"" + module + "\"\n"))
+		writer.Close()
+		dir := filepath.Join(base, "proxy", filepath.FromSlash(module), "@v")
+		os.MkdirAll(dir, 0777)
+		ioutil.WriteFile(filepath.Join(dir, "list"), []byte("v1.0.0\n"), 0666)
+		ioutil.WriteFile(filepath.Join(dir, "v1.0.0.info"), []byte(`{"Version":"v1.0.0"}`), 0666)
+		ioutil.WriteFile(filepath.Join(dir, "v1.0.0.zip"), buffer.Bytes(), 0666)
+	}
+
+	jobs, history := flag_jobs, flag_history
+	flag_proxy, flag_jobs, flag_history = "file://"+filepath.ToSlash(filepath.Join(base, "proxy")), 4, 0
+	defer func() {
+		flag_proxy, flag_jobs, flag_history = "", jobs, history
+	}()
+
+	host := func(name string, imports ...string) string {
+		dir := filepath.Join(base, name)
+		os.MkdirAll(dir, 0777)
+		content := "package host\n\n"
+		for _, path := range imports {
+			content += directivePrefix + "import " + path + "\n"
+		}
+		ioutil.WriteFile(filepath.Join(dir, "host.go"), []byte(content), 0666)
+		return dir
+	}
+
+	// capture returns what fn prints on standard output
+	capture := func(fn func()) string {
+		file, _ := ioutil.TempFile(base, "stdout.")
+		stdout := os.Stdout
+		os.Stdout = file
+		fn()
+		os.Stdout = stdout
+		file.Close()
+		content, _ := ioutil.ReadFile(file.Name())
+		return string(content)
+	}
+
+	// Printed (and recorded in smuggol.lock) in order, as if one after another
+	dir := host("host", "example.com/charlie", "example.com/alpha", "example.com/bravo")
+	output := capture(func() {
+		Is(mainDirective(dir, nil), nil)
+	})
+	_, relativeDir := relative(dir, dir)
+	charlie := strings.Index(output, "# "+filepath.Join(relativeDir, "charlie")+": ")
+	alpha := strings.Index(output, "# "+filepath.Join(relativeDir, "alpha")+": ")
+	bravo := strings.Index(output, "# "+filepath.Join(relativeDir, "bravo")+": ")
+	Is(charlie >= 0 && charlie < alpha && alpha < bravo, true, output)
+	Is(strings.Index(output, "+ "+filepath.Join(relativeDir, "alpha", "alpha.go")) > charlie, true)
+	lock, err := readLock(dir)
+	Is(err, nil)
+	Is(len(lock.Imports), 3)
+	for _, name := range []string{"alpha", "bravo", "charlie"} {
+		Is(lock.get(name).Version, "v1.0.0")
+		content, err := ioutil.ReadFile(filepath.Join(dir, name, name+".go"))
+		Is(err, nil)
+		Like(string(content), `const Name = "example.com/`+name+`"`)
+	}
+
+	// A failure stops everything after it (undone, if already written), but not before it
+	flag_quiet = true
+	defer func() {
+		flag_quiet = false
+	}()
+	dir = host("failure", "example.com/alpha", "example.com/nothing", "example.com/bravo")
+	err = mainDirective(dir, nil)
+	Like(err, `failure/host\.go:4:1: .*example\.com/nothing`)
+	Is(errors.Is(err, ErrSourceNotFound), true)
+	_, err = os.Stat(filepath.Join(dir, "alpha", "alpha.go"))
+	Is(err, nil)
+	_, err = os.Stat(filepath.Join(dir, "bravo"))
+	Is(os.IsNotExist(err), true)
+	lock, err = readLock(dir)
+	Is(err, nil)
+	Is(len(lock.Imports), 1)
+
+	// Two imports into the same subordinate package (xyzzy) are a conflict
+	dir = host("conflict", "example.com/xyzzy", "example.com/plugh", "example.com/alpha")
+	err = mainDirective(dir, nil)
+	Like(err, `conflict/host\.go:4:1: conflict: example\.com/plugh is already imported as xyzzy \(by .*conflict/host\.go:3:1\)`)
+	content, err := ioutil.ReadFile(filepath.Join(dir, "xyzzy", "xyzzy.go"))
+	Is(err, nil)
+	Like(string(content), `const Name = "example.com/xyzzy"`)
+	_, err = os.Stat(filepath.Join(dir, "alpha"))
+	Is(os.IsNotExist(err), true)
+
+	// ...one at a time (-jobs=1) as well
+	flag_jobs = 1
+	dir = host("conflict1", "example.com/xyzzy", "example.com/plugh", "example.com/alpha")
+	err = mainDirective(dir, nil)
+	Like(err, `conflict1/host\.go:4:1: conflict: example\.com/plugh is already imported as xyzzy \(by .*conflict1/host\.go:3:1\)`)
+	Is(errors.Is(err, ErrConflict), true)
+	Is(ExitCode(err), 10)
+	content, err = ioutil.ReadFile(filepath.Join(dir, "xyzzy", "xyzzy.go"))
+	Is(err, nil)
+	Like(string(content), `const Name = "example.com/xyzzy"`)
+	_, err = os.Stat(filepath.Join(dir, "alpha"))
+	Is(os.IsNotExist(err), true)
+}
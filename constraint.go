@@ -0,0 +1,102 @@
+package smuggol
+
+import (
+	"bytes"
+	"fmt"
+	"go/build/constraint"
+)
+
+// parseConstraint parses a build constraint expression (as given to -build), e.g. "debug && !race"
+func parseConstraint(expression string) (constraint.Expr, error) {
+	if expression == "" {
+		return nil, nil
+	}
+	result, err := constraint.Parse("//go:build " + expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid build constraint %q: %s", expression, err)
+	}
+	return result, nil
+}
+
+// addConstraint adds the build constraint expr to the Go source in content, combining it (with &&)
+// with any constraint already there (//go:build, or // +build lines). The result has a //go:build
+// line (and the equivalent // +build lines, for older versions of Go) right after the generated
+// header, if there is one, or else at the top
+func addConstraint(content []byte, expr constraint.Expr) ([]byte, error) {
+	if expr == nil {
+		return content, nil
+	}
+	lines := bytes.SplitAfter(content, []byte("\n"))
+
+	var goBuild constraint.Expr
+	plusBuild := []constraint.Expr{}
+	kept := make([][]byte, 0, len(lines))
+	index := 0
+	// Constraints can only be in the comments before the package clause
+	for ; index < len(lines); index++ {
+		line := bytes.TrimSpace(lines[index])
+		if len(line) > 0 && !bytes.HasPrefix(line, []byte("//")) {
+			break
+		}
+		text := string(line)
+		if constraint.IsGoBuild(text) || constraint.IsPlusBuild(text) {
+			parsed, err := constraint.Parse(text)
+			if err != nil {
+				return nil, err
+			}
+			if constraint.IsGoBuild(text) {
+				goBuild = parsed
+			} else {
+				plusBuild = append(plusBuild, parsed)
+			}
+			// Drop the blank line that separated the constraint from what follows
+			if index+1 < len(lines) && len(bytes.TrimSpace(lines[index+1])) == 0 {
+				index++
+			}
+			continue
+		}
+		kept = append(kept, lines[index])
+	}
+	kept = append(kept, lines[index:]...)
+
+	existing := goBuild
+	if existing == nil {
+		for _, parsed := range plusBuild {
+			if existing == nil {
+				existing = parsed
+			} else {
+				existing = &constraint.AndExpr{X: existing, Y: parsed}
+			}
+		}
+	}
+	if existing != nil {
+		expr = &constraint.AndExpr{X: existing, Y: expr}
+	}
+
+	var block bytes.Buffer
+	fmt.Fprintf(&block, "//go:build %s\n", expr)
+	if lines, err := constraint.PlusBuildLines(expr); err == nil {
+		for _, line := range lines {
+			fmt.Fprintf(&block, "%s\n", line)
+		}
+	}
+	block.WriteString("\n")
+
+	// After the generated header (and the blank line after it)
+	at := 0
+	if _, ok := parseHeader(content); ok && len(kept) > 0 {
+		at = 1
+		if len(kept) > 1 && len(bytes.TrimSpace(kept[1])) == 0 {
+			at = 2
+		}
+	}
+	var result bytes.Buffer
+	for _, line := range kept[:at] {
+		result.Write(line)
+	}
+	result.Write(block.Bytes())
+	for _, line := range kept[at:] {
+		result.Write(line)
+	}
+	return result.Bytes(), nil
+}
@@ -0,0 +1,39 @@
+package smuggol
+
+import (
+	. "github.com/robertkrimen/smuggol/terst"
+	"testing"
+)
+
+func TestConstraint(t *testing.T) {
+	Terst(t)
+
+	expr, err := parseConstraint("")
+	Is(err, nil)
+	Is(expr == nil, true)
+
+	content, err := addConstraint([]byte("package xyzzy\n"), nil)
+	Is(err, nil)
+	Is(string(content), "package xyzzy\n")
+
+	_, err = parseConstraint("debug &&")
+	Like(err, `^invalid build constraint "debug &&"`)
+
+	expr, err = parseConstraint("debug")
+	Is(err, nil)
+
+	content, err = addConstraint([]byte("package xyzzy\n"), expr)
+	Is(err, nil)
+	Is(string(content), "//go:build debug\n// +build debug\n\npackage xyzzy\n")
+
+	// Combined with the existing constraint, after the generated header
+	header := _header{tool: "smuggol", kind: "from", source: "github.com/robertkrimen/xyzzy"}.String()
+	content, err = addConstraint([]byte(header+"\n\n// Copyright\n\n//go:build linux || darwin\n// +build linux darwin\n\npackage xyzzy\n"), expr)
+	Is(err, nil)
+	Is(string(content), header+"\n\n//go:build (linux || darwin) && debug\n// +build linux darwin\n// +build debug\n\n// Copyright\n\npackage xyzzy\n")
+
+	// Only // +build lines (which are ANDed together)
+	content, err = addConstraint([]byte("// +build linux\n// +build amd64\n\npackage xyzzy\n\n// +build ignore\n"), expr)
+	Is(err, nil)
+	Is(string(content), "//go:build linux && amd64 && debug\n// +build linux,amd64,debug\n\npackage xyzzy\n\n// +build ignore\n")
+}
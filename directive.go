@@ -0,0 +1,218 @@
+package smuggol
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A directive is a smuggol instruction embedded in the source of the host package:
+//
+//      //smuggol:import github.com/robertkrimen/terst
+//      //smuggol:import github.com/robertkrimen/terst as tst
+//      //smuggol:import github.com/robertkrimen/terst exclude=debug_*.go,/_table\.go$/
+//      //smuggol:import github.com/robertkrimen/dbg build=debug
+//
+// An include= or exclude= option adds to -include or -exclude (see _fileFilter), and a build=
+// option (without spaces, e.g. build=debug&&!race) replaces -build, for that import alone
+//
+// Like //go:generate, there is no space between the // and "smuggol:"
+type directive struct {
+	verb     string
+	path     string
+	name     string
+	include  []string
+	exclude  []string
+	build    string
+	suffix   string // For a version side by side with another (see versionDirectives)
+	position token.Position
+}
+
+const directivePrefix = "//smuggol:"
+
+func (self directive) String() string {
+	result := fmt.Sprintf("%s%s %s", directivePrefix, self.verb, self.path)
+	if self.name != "" {
+		result += " as " + self.name
+	}
+	if len(self.include) > 0 {
+		result += " include=" + strings.Join(self.include, ",")
+	}
+	if len(self.exclude) > 0 {
+		result += " exclude=" + strings.Join(self.exclude, ",")
+	}
+	if self.build != "" {
+		result += " build=" + self.build
+	}
+	return result
+}
+
+func (self directive) import_(dst string, extra interface{}) _import {
+	result := newImport(dst, self.path, extra)
+	result.name = self.name
+	result.include = append(result.include, self.include...)
+	result.exclude = append(result.exclude, self.exclude...)
+	if self.build != "" {
+		result.build = self.build
+	}
+	if self.suffix != "" {
+		result.suffix = self.suffix
+		if consumer, err := filepath.Abs(self.position.Filename); err == nil {
+			result.consumers = []string{consumer}
+		}
+	}
+	return result
+}
+
+// parseDirective parses a single comment line, returning ok = false if the comment
+// is not a smuggol directive at all
+func parseDirective(text string, position token.Position) (result directive, ok bool, err error) {
+	if !strings.HasPrefix(text, directivePrefix) {
+		return
+	}
+	ok = true
+	result.position = position
+
+	fields := strings.Fields(text[len(directivePrefix):])
+	if len(fields) == 0 {
+		err = fmt.Errorf("%s: empty smuggol directive", position)
+		return
+	}
+	result.verb = fields[0]
+	fields = fields[1:]
+
+	switch result.verb {
+	case "import":
+		arguments := []string{}
+		for _, field := range fields {
+			switch {
+			case strings.HasPrefix(field, "include="):
+				result.include = append(result.include, splitList(strings.TrimPrefix(field, "include="))...)
+			case strings.HasPrefix(field, "exclude="):
+				result.exclude = append(result.exclude, splitList(strings.TrimPrefix(field, "exclude="))...)
+			case strings.HasPrefix(field, "build="):
+				result.build = strings.TrimPrefix(field, "build=")
+			default:
+				arguments = append(arguments, field)
+			}
+		}
+		fields = arguments
+		if _, err = newFileFilter(result.include, result.exclude); err != nil {
+			err = fmt.Errorf("%s: %s", position, err)
+			return
+		}
+		if _, err = parseConstraint(result.build); err != nil {
+			err = fmt.Errorf("%s: %s", position, err)
+			return
+		}
+		switch {
+		case len(fields) == 1:
+		case len(fields) == 3 && fields[1] == "as":
+			result.name = fields[2]
+			if !token.IsIdentifier(result.name) {
+				err = fmt.Errorf("%s: invalid package name %q", position, result.name)
+				return
+			}
+		default:
+			err = fmt.Errorf("%s: usage: %simport <path> [as <name>] [include=<patterns>] [exclude=<patterns>] [build=<constraint>]", position, directivePrefix)
+			return
+		}
+		result.path = fields[0]
+	default:
+		err = fmt.Errorf("%s: unknown smuggol directive %q", position, result.verb)
+	}
+	return
+}
+
+// scanDirectives parses the .go files in dir (including test files) and returns every
+// smuggol directive found, in file and then line order
+func scanDirectives(dir string) ([]directive, error) {
+	fileSet := token.NewFileSet()
+	filter := func(info os.FileInfo) bool {
+		return !strings.HasPrefix(info.Name(), ".")
+	}
+	pkgs, err := parser.ParseDir(fileSet, dir, filter, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []*ast.File{}
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			files = append(files, file)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return fileSet.Position(files[i].Package).Filename < fileSet.Position(files[j].Package).Filename
+	})
+
+	result := []directive{}
+	seen := map[string]token.Position{}
+	for _, file := range files {
+		for _, group := range file.Comments {
+			for _, comment := range group.List {
+				position := fileSet.Position(comment.Slash)
+				directive, ok, err := parseDirective(comment.Text, position)
+				if !ok {
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+				key := directive.name
+				if key == "" {
+					key = directive.path
+				}
+				if previous, exists := seen[key]; exists {
+					return nil, fmt.Errorf("%s: %q already imported at %s", position, key, previous)
+				}
+				seen[key] = position
+				result = append(result, directive)
+			}
+		}
+	}
+	err = versionDirectives(result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// mainDirective performs every //smuggol:import found in the host package at dst, concurrently
+// with -jobs (see runDirectives). More than one directive always goes through runDirectives (even
+// with -jobs=1), which is what keeps two of them from importing into the same subordinate package
+func mainDirective(dst string, extra interface{}) error {
+	if dst == "" {
+		dst = "."
+	}
+	directives, err := scanDirectives(dst)
+	if err != nil {
+		return err
+	}
+	if len(directives) == 0 {
+		return fmt.Errorf("no %simport directives found (in %s)", directivePrefix, dst)
+	}
+	if len(directives) > 1 {
+		jobs := flag_jobs
+		if jobs < 1 {
+			jobs = 1
+		}
+		return runDirectives(dst, directives, extra, jobs)
+	}
+	for _, directive := range directives {
+		if flag_verbose {
+			say("# %s (%s)", directive, directive.position)
+		}
+		err := directive.import_(dst, extra).run(context.Background())
+		if err != nil {
+			return fmt.Errorf("%s: %w", directive.position, err)
+		}
+	}
+	return nil
+}
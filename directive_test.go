@@ -0,0 +1,59 @@
+package smuggol
+
+import (
+	. "github.com/robertkrimen/smuggol/terst"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirective(t *testing.T) {
+	Terst(t)
+
+	dir, err := ioutil.TempDir("", "smuggol.")
+	Is(err, nil)
+	if err != nil {
+		FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "xyzzy.go"), []byte(kiltGraveTrim(`
+package xyzzy
+
+//go:generate smuggol
+//smuggol:import github.com/robertkrimen/terst
+//smuggol:import github.com/robertkrimen/dbg as debug
+
+// smuggol:import github.com/robertkrimen/kilt
+    `)), 0666)
+
+	directives, err := scanDirectives(dir)
+	Is(err, nil)
+	Is(len(directives), 2)
+	if len(directives) == 2 {
+		Is(directives[0].path, "github.com/robertkrimen/terst")
+		Is(directives[0].name, "")
+		Is(directives[0].position.Line, 4)
+		Is(directives[1].path, "github.com/robertkrimen/dbg")
+		Is(directives[1].name, "debug")
+		Is(directives[1].String(), "//smuggol:import github.com/robertkrimen/dbg as debug")
+	}
+
+	_, ok, err := parseDirective("//smuggol:export xyzzy", token.Position{})
+	Is(ok, true)
+	IsNot(err, nil)
+
+	_, ok, err = parseDirective("//smuggol:import xyzzy as 1nvalid", token.Position{})
+	Is(ok, true)
+	IsNot(err, nil)
+
+	ioutil.WriteFile(filepath.Join(dir, "zzz.go"), []byte("package xyzzy\n//smuggol:import github.com/robertkrimen/terst\n"), 0666)
+	_, err = scanDirectives(dir)
+	Like(err, `"github.com/robertkrimen/terst" already imported at .*xyzzy.go:4`)
+
+	content, err := renamePackage([]byte("// Package terst ...\npackage terst // Comment\n\nfunc Is() {}\n"), "tst")
+	Is(err, nil)
+	Is(string(content), "// Package terst ...\npackage tst // Comment\n\nfunc Is() {}\n")
+}
@@ -0,0 +1,79 @@
+package smuggol
+
+import (
+	"errors"
+)
+
+// The kinds of error (see Error), for errors.Is:
+//
+//      err := smuggol.Run("terst-import", "github.com/robertkrimen/terst", nil, os.Args[1:])
+//      if errors.Is(err, smuggol.ErrPolicy) {
+//          ...
+//      }
+var (
+	ErrNoHostPackage  = errors.New("no host package")          // There is no Go package to import into (with extras, a shim, or -prune)
+	ErrSourceNotFound = errors.New("import package not found") // The import package (or the version asked for) does not exist
+	ErrFetch          = errors.New("fetch failed")             // The import package could not be fetched (go get, a proxy, or git)
+	ErrTemplate       = errors.New("template error")           // An extra could not be loaded, parsed, or rendered
+	ErrDrift          = errors.New("drift detected")           // What is on disk (or downloaded) does not match smuggol.lock (or go.sum)
+	ErrPolicy         = errors.New("policy violation")         // The import package is denied (by the policy, -license-deny, or -apidiff), or filtered down to nothing
+	ErrVerify         = errors.New("type errors")              // The import (or host) package does not type-check: with -verify, or after a filter
+	ErrConflict       = errors.New("conflict")                 // Two directives import into the same subordinate package
+)
+
+// exitCodes are the exit codes of Main, by kind of error. Anything else is 1 (and 2 is a usage error)
+var exitCodes = []struct {
+	kind error
+	code int
+}{
+	{ErrNoHostPackage, 3},
+	{ErrSourceNotFound, 4},
+	{ErrFetch, 5},
+	{ErrTemplate, 6},
+	{ErrDrift, 7},
+	{ErrPolicy, 8},
+	{ErrVerify, 9},
+	{ErrConflict, 10},
+}
+
+// Error is an error of a particular kind (ErrNoHostPackage, ErrFetch, etc.), for errors.Is.
+// The error itself (Err) is still there, for errors.Is or errors.As
+type Error struct {
+	Kind error
+	Err  error
+}
+
+// fail returns err as an Error of kind (or nil, if err is nil)
+func fail(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+func (self *Error) Error() string {
+	return self.Err.Error()
+}
+
+func (self *Error) Unwrap() error {
+	return self.Err
+}
+
+func (self *Error) Is(target error) bool {
+	return target == self.Kind
+}
+
+// ExitCode returns the exit code (of Main) for err: 0 for nil, 3 and up for each kind of error
+// (ErrNoHostPackage is 3, ErrSourceNotFound 4, ErrFetch 5, ErrTemplate 6, ErrDrift 7, ErrPolicy 8,
+// ErrVerify 9, and ErrConflict 10), or else 1
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	for _, exit := range exitCodes {
+		if errors.Is(err, exit.kind) {
+			return exit.code
+		}
+	}
+	return 1
+}
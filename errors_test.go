@@ -0,0 +1,35 @@
+package smuggol
+
+import (
+	"errors"
+	"fmt"
+	. "github.com/robertkrimen/smuggol/terst"
+	"os"
+	"testing"
+)
+
+func TestError(t *testing.T) {
+	Terst(t)
+
+	Is(fail(ErrPolicy, nil), nil)
+
+	err := fail(ErrFetch, &os.PathError{Op: "open", Path: "xyzzy", Err: os.ErrNotExist})
+	Is(err.Error(), "open xyzzy: file does not exist")
+	Is(errors.Is(err, ErrFetch), true)
+	Is(errors.Is(err, ErrPolicy), false)
+	Is(errors.Is(err, os.ErrNotExist), true)
+
+	// Still there, after wrapping (e.g. with the position of a directive)
+	err = fmt.Errorf("host.go:3:1: %w", err)
+	var kind *Error
+	Is(errors.As(err, &kind), true)
+	Is(kind.Kind, ErrFetch)
+	var path *os.PathError
+	Is(errors.As(err, &path), true)
+
+	Is(ExitCode(nil), 0)
+	Is(ExitCode(err), 5)
+	Is(ExitCode(fail(ErrNoHostPackage, os.ErrInvalid)), 3)
+	Is(ExitCode(fail(ErrVerify, os.ErrInvalid)), 9)
+	Is(ExitCode(os.ErrInvalid), 1)
+}
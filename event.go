@@ -0,0 +1,154 @@
+package smuggol
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// _event is a single thing that happened during an import, printed (with -json) as a line of JSON:
+//
+//      {"time":"...","action":"resolve","package":"github.com/robertkrimen/terst","revision":"...","dir":"..."}
+//      {"time":"...","action":"add","path":"terst/terst.go","sha1":"..."}
+//      {"time":"...","action":"done","package":"github.com/robertkrimen/terst","path":"terst","tally":{...}}
+//
+// The actions are: fetch, resolve, add, update, remove, unchanged, template, format, verify, change (with
+// -watch), apidiff (with -apidiff), done, and error
+type _event struct {
+	Time     time.Time      `json:"time"`
+	Action   string         `json:"action"`
+	Package  string         `json:"package,omitempty"`  // The import path of the import package
+	Version  string         `json:"version,omitempty"`  // The requested (or resolved) version
+	Revision string         `json:"revision,omitempty"` // The revision, if known
+	Sum      string         `json:"sum,omitempty"`      // The "h1:" hash of the module (fetch, via a proxy)
+	Dir      string         `json:"dir,omitempty"`      // Where the import package was found (resolve)
+	Path     string         `json:"path,omitempty"`     // The file (or directory) acted on
+	Sha1     string         `json:"sha1,omitempty"`     // The SHA-1 of the file, as written
+	License  string         `json:"license,omitempty"`  // The license, if the file is a license file
+	Template string         `json:"template,omitempty"` // The template the file was generated from
+	Files    []string       `json:"files,omitempty"`    // The files that changed (change)
+	Errors   []string       `json:"errors,omitempty"`   // The type errors (verify)
+	Change   string         `json:"change,omitempty"`   // A change to the API, e.g. "! removed: func Xyzzy" (apidiff)
+	Uses     []string       `json:"uses,omitempty"`     // Where the host package uses what changed (apidiff)
+	Tally    map[string]int `json:"tally,omitempty"`    // How many files were unchanged, updated, added, and removed (done)
+	Error    string         `json:"error,omitempty"`
+}
+
+// _output is where an import prints: standard output (text, or events) and standard error (diagnostics),
+// or else buffers, while it runs concurrently with other imports (see mainDirective)
+type _output struct {
+	buffer bool
+	stdout bytes.Buffer
+	stderr bytes.Buffer
+}
+
+type _outputKey struct{}
+
+// withOutput returns ctx, with output for whatever is done with it
+func withOutput(ctx context.Context, output *_output) context.Context {
+	return context.WithValue(ctx, _outputKey{}, output)
+}
+
+// outputOf returns the output of ctx: nil (standard output and error), unless withOutput
+func outputOf(ctx context.Context) *_output {
+	output, _ := ctx.Value(_outputKey{}).(*_output)
+	return output
+}
+
+func (self *_output) out() io.Writer {
+	if self == nil || !self.buffer {
+		return os.Stdout
+	}
+	return &self.stdout
+}
+
+func (self *_output) err() io.Writer {
+	if self == nil || !self.buffer {
+		return os.Stderr
+	}
+	return &self.stderr
+}
+
+// flush prints (and empties) the buffers
+func (self *_output) flush() {
+	if self == nil || !self.buffer {
+		return
+	}
+	os.Stdout.Write(self.stdout.Bytes())
+	os.Stderr.Write(self.stderr.Bytes())
+	self.stdout.Reset()
+	self.stderr.Reset()
+}
+
+// emit prints event, with -json
+func (self *_output) emit(event _event) {
+	if !flag_json {
+		return
+	}
+	event.Time = time.Now().UTC()
+	encoder := json.NewEncoder(self.out())
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(event)
+}
+
+// say prints a line of text, unless -quiet (or -json)
+func (self *_output) say(format string, arguments ...interface{}) {
+	if flag_quiet || flag_json {
+		return
+	}
+	fmt.Fprintf(self.out(), format+"\n", arguments...)
+}
+
+// emit prints event on standard output, with -json
+func emit(event _event) {
+	(*_output)(nil).emit(event)
+}
+
+// say prints a line of text on standard output, unless -quiet (or -json)
+func say(format string, arguments ...interface{}) {
+	(*_output)(nil).say(format, arguments...)
+}
+
+// report prints what has happened to the file at path (see change), as relativePath: a line
+// of text, e.g. "+ xyzzy/xyzzy.go", or an event. An unchanged file is only printed with -verbose
+func (self *_journal) report(path, relativePath, license string) {
+	change := self.change(path)
+	if change == "" {
+		return
+	}
+	if flag_json {
+		event := _event{
+			Action:  map[string]string{"+": "add", "~": "update", "-": "remove", "=": "unchanged"}[change],
+			Path:    relativePath,
+			License: license,
+		}
+		if content, err := ioutil.ReadFile(path); err == nil {
+			event.Sha1 = kilt.Sha1(content)
+		}
+		self.output.emit(event)
+		return
+	}
+	if change == "=" && !flag_verbose {
+		return
+	}
+	if license != "" {
+		self.output.say("%s %s (%s)", change, relativePath, license)
+		return
+	}
+	self.output.say("%s %s", change, relativePath)
+}
+
+// tally returns the tally of a _tally (see _journal.tally), for an event
+func (self _tally) tally() map[string]int {
+	return map[string]int{
+		"unchanged": self.unchanged,
+		"updated":   self.updated,
+		"added":     self.added,
+		"removed":   self.removed,
+	}
+}
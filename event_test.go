@@ -0,0 +1,77 @@
+package smuggol
+
+import (
+	"encoding/json"
+	. "github.com/robertkrimen/smuggol/terst"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEvent(t *testing.T) {
+	Terst(t)
+
+	base, err := ioutil.TempDir("", "smuggol.")
+	Is(err, nil)
+	if err != nil {
+		FailNow()
+	}
+	defer os.RemoveAll(base)
+
+	// capture returns what fn prints on standard output
+	capture := func(fn func()) string {
+		file, _ := ioutil.TempFile(base, "stdout.")
+		stdout := os.Stdout
+		os.Stdout = file
+		fn()
+		os.Stdout = stdout
+		file.Close()
+		content, _ := ioutil.ReadFile(file.Name())
+		return string(content)
+	}
+
+	added := filepath.Join(base, "added.go")
+	same := filepath.Join(base, "same.go")
+	ioutil.WriteFile(same, []byte("package xyzzy\n"), 0666)
+	journal := newJournal()
+	journal.writeFile(added, []byte("package xyzzy // Xyzzy\n"))
+	journal.writeFile(same, []byte("package xyzzy\n"))
+
+	output := capture(func() {
+		journal.report(added, "xyzzy/added.go", "")
+		journal.report(same, "xyzzy/same.go", "")
+		say("# xyzzy: %s", journal.tally())
+		emit(_event{Action: "done"})
+	})
+	Is(output, "+ xyzzy/added.go\n# xyzzy: 1 unchanged, 0 updated, 1 added, 0 removed\n")
+
+	flag_json = true
+	defer func() {
+		flag_json = false
+	}()
+	output = capture(func() {
+		journal.report(added, "xyzzy/added.go", "")
+		journal.report(same, "xyzzy/same.go", "")
+		say("# xyzzy: %s", journal.tally())
+		emit(_event{Action: "done", Path: "xyzzy", Tally: journal.tally().tally()})
+	})
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	Is(len(lines), 3)
+	events := []_event{}
+	for _, line := range lines {
+		event := _event{}
+		Is(json.Unmarshal([]byte(line), &event), nil)
+		events = append(events, event)
+	}
+	if len(events) == 3 {
+		Is(events[0].Action, "add")
+		Is(events[0].Path, "xyzzy/added.go")
+		Is(events[0].Sha1, kilt.Sha1([]byte("package xyzzy // Xyzzy\n")))
+		Is(events[1].Action, "unchanged")
+		Is(events[2].Action, "done")
+		Is(events[2].Tally, map[string]int{"unchanged": 1, "updated": 0, "added": 1, "removed": 0})
+		Is(events[2].Time.IsZero(), false)
+	}
+}
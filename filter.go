@@ -0,0 +1,141 @@
+package smuggol
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// _fileFilter decides which files of the import package are copied. A pattern is either
+// a glob (path.Match) or, between slashes, a regular expression, matched against the file name:
+//
+//      -exclude "debug_*.go,/_table[0-9]*\.go$/"
+type _fileFilter struct {
+	include []func(string) bool
+	exclude []func(string) bool
+}
+
+// compilePattern compiles a single glob or /regexp/ pattern
+func compilePattern(pattern string) (func(string) bool, error) {
+	if len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		expression, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %s", pattern, err)
+		}
+		return expression.MatchString, nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %s", pattern, err)
+	}
+	return func(name string) bool {
+		matched, _ := path.Match(pattern, name)
+		return matched
+	}, nil
+}
+
+// newFileFilter returns a filter for the include and exclude patterns, or nil if there are none
+func newFileFilter(include, exclude []string) (*_fileFilter, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+	self := &_fileFilter{}
+	for _, pattern := range include {
+		match, err := compilePattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		self.include = append(self.include, match)
+	}
+	for _, pattern := range exclude {
+		match, err := compilePattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		self.exclude = append(self.exclude, match)
+	}
+	return self, nil
+}
+
+// match reports whether name is included (by any include pattern, if there are any)
+// and not excluded (by any exclude pattern)
+func (self *_fileFilter) match(name string) bool {
+	if self == nil {
+		return true
+	}
+	included := len(self.include) == 0
+	for _, match := range self.include {
+		if match(name) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, match := range self.exclude {
+		if match(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// filter returns the files that match
+func (self *_fileFilter) filter(files []string) []string {
+	result := []string{}
+	for _, name := range files {
+		if self.match(name) {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// checkFiltered makes sure that files (the ones that survived the filter) still form a complete
+// package: every type error they have that all (the whole package) does not is reported,
+// with its position. It fails if there are any
+func checkFiltered(ctx context.Context, importPath, dir string, all, files []string) error {
+	if len(files) == 0 {
+		return fail(ErrPolicy, fmt.Errorf("filter: no files left in %s", importPath))
+	}
+	if len(files) == len(all) {
+		return nil
+	}
+	before, err := typeCheck(importPath, dir, all)
+	if err != nil {
+		return err
+	}
+	after, err := typeCheck(importPath, dir, files)
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for _, problem := range before.errors {
+		existing[problem.Error()] = true
+	}
+	count := 0
+	for _, problem := range after.errors {
+		if existing[problem.Error()] {
+			continue
+		}
+		count++
+		if !flag_quiet {
+			// Report positions relative to the import path, rather than wherever the source was extracted to
+			position := problem.Fset.Position(problem.Pos)
+			if relative, err := filepath.Rel(dir, position.Filename); err == nil {
+				position.Filename = path.Join(importPath, filepath.ToSlash(relative))
+			}
+			fmt.Fprintf(outputOf(ctx).err(), "%s: %s\n", position, problem.Msg)
+		}
+	}
+	switch count {
+	case 0:
+		return nil
+	case 1:
+		return fail(ErrVerify, fmt.Errorf("filter: 1 type error (the remaining files are not a complete package)"))
+	}
+	return fail(ErrVerify, fmt.Errorf("filter: %d type errors (the remaining files are not a complete package)", count))
+}
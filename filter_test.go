@@ -0,0 +1,68 @@
+package smuggol
+
+import (
+	"context"
+	"errors"
+	. "github.com/robertkrimen/smuggol/terst"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	Terst(t)
+
+	filter, err := newFileFilter(nil, nil)
+	Is(err, nil)
+	Is(filter.match("xyzzy.go"), true)
+
+	filter, err = newFileFilter(nil, []string{"debug_*.go", `/_table[0-9]*\.go$/`})
+	Is(err, nil)
+	Is(filter.filter([]string{"xyzzy.go", "debug_xyzzy.go", "unicode_table1.go", "table.go"}), []string{"xyzzy.go", "table.go"})
+
+	filter, err = newFileFilter([]string{"xyzzy*.go"}, []string{"*_debug.go"})
+	Is(err, nil)
+	Is(filter.filter([]string{"xyzzy.go", "xyzzy_debug.go", "nothing.go"}), []string{"xyzzy.go"})
+
+	_, err = newFileFilter([]string{"/[/"}, nil)
+	Like(err, `invalid pattern "/\[/"`)
+	_, err = newFileFilter(nil, []string{"["})
+	Like(err, `invalid pattern "\["`)
+
+	base, err := ioutil.TempDir("", "smuggol.")
+	Is(err, nil)
+	if err != nil {
+		FailNow()
+	}
+	defer os.RemoveAll(base)
+
+	ioutil.WriteFile(filepath.Join(base, "xyzzy.go"), []byte("package xyzzy\n\nfunc Xyzzy() int { return table[0] }\n"), 0666)
+	ioutil.WriteFile(filepath.Join(base, "table.go"), []byte("package xyzzy\n\nvar table = []int{1, 2, 3}\n"), 0666)
+	ioutil.WriteFile(filepath.Join(base, "debug.go"), []byte("package xyzzy\n\nfunc debug() {}\n"), 0666)
+	all := []string{"debug.go", "table.go", "xyzzy.go"}
+
+	flag_quiet = true
+	defer func() {
+		flag_quiet = false
+	}()
+	Is(checkFiltered(context.Background(), "example.com/xyzzy", base, all, []string{"table.go", "xyzzy.go"}), nil)
+	err = checkFiltered(context.Background(), "example.com/xyzzy", base, all, []string{"debug.go", "xyzzy.go"})
+	Like(err, `^filter: 1 type error`)
+	Is(errors.Is(err, ErrVerify), true)
+	err = checkFiltered(context.Background(), "example.com/xyzzy", base, all, []string{})
+	Like(err, `no files left`)
+	Is(errors.Is(err, ErrPolicy), true)
+
+	directive, ok, err := parseDirective("//smuggol:import example.com/xyzzy as debug exclude=debug.go,/_test/ include=*.go", token.Position{})
+	Is(ok, true)
+	Is(err, nil)
+	Is(directive.name, "debug")
+	Is(directive.include, []string{"*.go"})
+	Is(directive.exclude, []string{"debug.go", "/_test/"})
+	Is(directive.String(), "//smuggol:import example.com/xyzzy as debug include=*.go exclude=debug.go,/_test/")
+
+	_, _, err = parseDirective("//smuggol:import example.com/xyzzy exclude=[", token.Position{})
+	Like(err, `invalid pattern`)
+}
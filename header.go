@@ -0,0 +1,96 @@
+package smuggol
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+)
+
+// _header is the comment at the top of every file smuggol generates, following the
+// "Code generated ... DO NOT EDIT." convention (so that linters, gopls, etc. leave it be):
+//
+//      // Code generated by terst-import (smuggol) from github.com/robertkrimen/terst. DO NOT EDIT.
+//      // Code generated by terst-import (smuggol) from github.com/robertkrimen/terst@v1.2.3 (<revision>). DO NOT EDIT.
+//      // Code generated by terst-import (smuggol) for github.com/robertkrimen/terst. DO NOT EDIT.
+//
+// A copied file is "from" the import package, while an extra file is "for" it. The header
+// written by older versions of smuggol is still recognized:
+//
+//      // This file was AUTOMATICALLY GENERATED by terst-import (smuggol) from github.com/robertkrimen/terst
+type _header struct {
+	tool     string
+	kind     string // "from" or "for"
+	source   string // The import path
+	version  string
+	revision string
+}
+
+const headerLegacyMarker = "This file was AUTOMATICALLY GENERATED"
+
+var (
+	headerPattern       = regexp.MustCompile(`^// Code generated by (\S+) \(smuggol\) (from|for) ([^\s@]+)(?:@(\S+))?(?: \((\S+)\))?\. DO NOT EDIT\.\r?$`)
+	headerLegacyPattern = regexp.MustCompile(`^// ` + headerLegacyMarker + ` by (\S+) \(smuggol\) (from|for) ([^\s@]+)(?:@(\S+))?(?: \((\S+)\))?[ \t]*\r?$`)
+)
+
+func (self _header) String() string {
+	return fmt.Sprintf("// Code generated by %s (smuggol) %s %s. DO NOT EDIT.", self.tool, self.kind, self.origin())
+}
+
+// origin is the import path, with the version (and revision) when pinned
+func (self _header) origin() string {
+	origin := self.source
+	if self.version != "" {
+		origin += "@" + self.version
+		if self.revision != "" && self.revision != self.version {
+			origin += " (" + self.revision + ")"
+		}
+	}
+	return origin
+}
+
+// parseHeader parses the first line of content as a header
+func parseHeader(content []byte) (_header, bool) {
+	line := content
+	if index := bytes.IndexByte(line, '\n'); index >= 0 {
+		line = line[:index]
+	}
+	match := headerPattern.FindSubmatch(line)
+	if match == nil {
+		match = headerLegacyPattern.FindSubmatch(line)
+	}
+	if match == nil {
+		return _header{}, false
+	}
+	return _header{
+		tool:     string(match[1]),
+		kind:     string(match[2]),
+		source:   string(match[3]),
+		version:  string(match[4]),
+		revision: string(match[5]),
+	}, true
+}
+
+// isGenerated reports whether content (the start of a file) looks like something smuggol generated
+func isGenerated(content []byte) bool {
+	if _, ok := parseHeader(content); ok {
+		return true
+	}
+	return bytes.Contains(content, []byte(headerLegacyMarker))
+}
+
+// readHead reads (up to) the first size bytes of the file at path
+func readHead(path string, size int) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	buffer := make([]byte, size)
+	count, err := io.ReadFull(file, buffer)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return buffer[:count], nil
+}
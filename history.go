@@ -0,0 +1,402 @@
+package smuggol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The history (in .smuggol, in the host package) keeps the last few states of every smuggled
+// package, so that an update can be rolled back:
+//
+//      .smuggol/history.json           # The states, newest first
+//      .smuggol/objects/ab/cdef...     # The content of every file in a state, by SHA-1
+const historyDir = ".smuggol"
+
+type _history struct {
+	States []_historyState `json:"states"`
+}
+
+// _historyState is every file of a smuggled package (the subordinate package, its license
+// files, and any extras) as of an import, along with its lockfile entry
+type _historyState struct {
+	Name  string            `json:"name"`
+	Time  time.Time         `json:"time"`
+	Entry _lockImport       `json:"entry"`
+	Files map[string]string `json:"files"` // Path (relative to the host package) => SHA-1
+}
+
+func (self _historyState) String() string {
+	return _header{source: self.Entry.Source, version: self.Entry.Version, revision: shortRevision(self.Entry.Revision)}.origin()
+}
+
+// readHistory reads the history in (host) dir, returning an empty history if there is none
+func readHistory(dir string) (*_history, error) {
+	self := &_history{}
+	path := filepath.Join(dir, historyDir, "history.json")
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return self, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(content, self)
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: path, Err: err}
+	}
+	for _, state := range self.States {
+		for file, sum := range state.Files {
+			if !validSum(sum) {
+				return nil, fmt.Errorf("%s: %s (of %s) has an invalid SHA-1: %q", path, file, state.Name, sum)
+			}
+		}
+	}
+	return self, nil
+}
+
+// validSum reports whether sum is a SHA-1 (as from kilt.Sha1), and so safe to use in objectPath
+func validSum(sum string) bool {
+	if len(sum) != 40 {
+		return false
+	}
+	for _, char := range sum {
+		if !('0' <= char && char <= '9' || 'a' <= char && char <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// states returns the states of the package name, newest first
+func (self *_history) states(name string) []_historyState {
+	result := []_historyState{}
+	for _, state := range self.States {
+		if state.Name == name {
+			result = append(result, state)
+		}
+	}
+	return result
+}
+
+// names returns the (sorted) name of every package with a state
+func (self *_history) names() []string {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, state := range self.States {
+		if !seen[state.Name] {
+			seen[state.Name] = true
+			result = append(result, state.Name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// same reports whether state is the same as other (the same files and lockfile entry), whenever each was taken
+func (self _historyState) same(other _historyState) bool {
+	entry, _ := json.Marshal(self.Entry)
+	otherEntry, _ := json.Marshal(other.Entry)
+	return self.Name == other.Name && bytes.Equal(entry, otherEntry) && reflect.DeepEqual(self.Files, other.Files)
+}
+
+// push adds state (as the newest), keeping at most limit states of the package. Nothing is
+// added if state is the same as the newest (see same), e.g. an import with nothing new
+func (self *_history) push(state _historyState, limit int) bool {
+	if states := self.states(state.Name); len(states) > 0 && states[0].same(state) {
+		return false
+	}
+	states := []_historyState{state}
+	count := 1
+	for _, existing := range self.States {
+		if existing.Name == state.Name {
+			if count >= limit {
+				continue
+			}
+			count++
+		}
+		states = append(states, existing)
+	}
+	self.States = states
+	return true
+}
+
+func objectPath(dir, sum string) string {
+	return filepath.Join(dir, historyDir, "objects", sum[:2], sum[2:])
+}
+
+// write writes the history (and the objects of any new state) into (host) dir, removing any
+// object that is no longer referenced
+func (self *_history) write(journal *_journal, dir string, objects map[string][]byte) error {
+	referenced := map[string]bool{}
+	for _, state := range self.States {
+		for _, sum := range state.Files {
+			referenced[sum] = true
+		}
+	}
+	for sum, content := range objects {
+		path := objectPath(dir, sum)
+		if _, err := os.Stat(path); err == nil || !referenced[sum] {
+			continue
+		}
+		err := journal.mkdirAll(filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		err = journal.writeFile(path, content)
+		if err != nil {
+			return err
+		}
+	}
+
+	root := filepath.Join(dir, historyDir, "objects")
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		sum := filepath.Base(filepath.Dir(path)) + info.Name()
+		if referenced[sum] {
+			return nil
+		}
+		return journal.remove(path)
+	})
+	if err != nil {
+		return err
+	}
+
+	content, err := json.MarshalIndent(self, "", "    ")
+	if err != nil {
+		return err
+	}
+	content = append(content, '\n')
+	return journal.writeFile(filepath.Join(dir, historyDir, "history.json"), content)
+}
+
+// recordHistory records the files (at paths) of the package of entry, as just imported into
+// the host package in dir. The oldest states are dropped, to keep at most limit
+func recordHistory(journal *_journal, dir string, entry _lockImport, paths []string, limit int) error {
+	if limit <= 0 {
+		return nil
+	}
+	history, err := readHistory(dir)
+	if err != nil {
+		return err
+	}
+	state := _historyState{
+		Name:  entry.Name,
+		Time:  time.Now().UTC().Truncate(time.Second),
+		Entry: entry,
+		Files: map[string]string{},
+	}
+	objects := map[string][]byte{}
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		sum := kilt.Sha1(content)
+		state.Files[filepath.ToSlash(relative)] = sum
+		objects[sum] = content
+	}
+	if !history.push(state, limit) {
+		return nil
+	}
+	err = journal.mkdirAll(filepath.Join(dir, historyDir))
+	if err != nil {
+		return err
+	}
+	return history.write(journal, dir, objects)
+}
+
+// historySubordinate reports whether dir is a subordinate package with a history (in its host package)
+func historySubordinate(dir string) (hostDir, name string, ok bool) {
+	hostDir, name = filepath.Dir(dir), filepath.Base(dir)
+	history, err := readHistory(hostDir)
+	if err != nil || name == "." || len(history.states(name)) == 0 {
+		return "", "", false
+	}
+	return hostDir, name, true
+}
+
+// historyTarget figures out the host package (hostDir) and smuggled package (name) for dir, which
+// is either a subordinate package or a host package (with a single smuggled package)
+func historyTarget(dir string) (hostDir, name string, err error) {
+	if dir == "" {
+		dir = "."
+	}
+	if hostDir, name, ok := historySubordinate(dir); ok {
+		return hostDir, name, nil
+	}
+	history, err := readHistory(dir)
+	if err != nil {
+		return "", "", err
+	}
+	names := history.names()
+	switch len(names) {
+	case 0:
+		return "", "", fmt.Errorf("no history (in %s)", dir)
+	case 1:
+		return dir, names[0], nil
+	}
+	return "", "", fmt.Errorf("more than one package has history (in %s): %s", dir, strings.Join(names, ", "))
+}
+
+// mainHistory lists the states of every smuggled package with a history in (host) dir, or
+// of the subordinate package in dir
+func mainHistory(dir string, output io.Writer) error {
+	if dir == "" {
+		dir = "."
+	}
+	hostDir, name, ok := historySubordinate(dir)
+	if !ok {
+		hostDir = dir
+	}
+	history, err := readHistory(hostDir)
+	if err != nil {
+		return err
+	}
+	names := history.names()
+	if ok {
+		names = []string{name}
+	}
+	for _, name := range names {
+		fmt.Fprintf(output, "%s\n", filepath.Join(hostDir, name))
+		for index, state := range history.states(name) {
+			files := fmt.Sprintf("%d files", len(state.Files))
+			if len(state.Files) == 1 {
+				files = "1 file"
+			}
+			if index == 0 {
+				files += " (current)"
+			}
+			fmt.Fprintf(output, "    %d\t%s\t%s\t%s\n", index, state.Time.Local().Format("2006-01-02 15:04:05"), state, files)
+		}
+	}
+	return nil
+}
+
+// rollback restores the package name (in the host package in dir) to the state n imports ago,
+// recording that as the newest state. Either every file is restored, or none is
+func rollback(dir, name string, n int) (err error) {
+	history, err := readHistory(dir)
+	if err != nil {
+		return err
+	}
+	states := history.states(name)
+	if n < 1 || n >= len(states) {
+		return fmt.Errorf("rollback: %s has %d earlier state(s)", filepath.Join(dir, name), len(states)-1)
+	}
+	current, target := states[0], states[n]
+
+	journal := newJournal()
+	defer func() {
+		if err != nil {
+			journal.restore()
+		}
+	}()
+
+	for file := range current.Files {
+		if _, exists := target.Files[file]; !exists {
+			err := journal.remove(filepath.Join(dir, filepath.FromSlash(file)))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	for file, sum := range target.Files {
+		content, err := os.ReadFile(objectPath(dir, sum))
+		if err != nil {
+			return err
+		}
+		if kilt.Sha1(content) != sum {
+			return fmt.Errorf("rollback: %s is corrupt", objectPath(dir, sum))
+		}
+		path := filepath.Join(dir, filepath.FromSlash(file))
+		err = journal.mkdirAll(filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		err = journal.writeFile(path, content)
+		if err != nil {
+			return err
+		}
+	}
+
+	files := []string{}
+	for file := range current.Files {
+		files = append(files, file)
+	}
+	for file := range target.Files {
+		if _, exists := current.Files[file]; !exists {
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		path := filepath.Join(dir, filepath.FromSlash(file))
+		journal.report(path, path, "")
+	}
+	say("# %s: rolled back to %s (%s)", filepath.Join(dir, name), target, journal.tally())
+	emit(_event{Action: "done", Package: target.Entry.Source, Version: target.Entry.Version, Revision: target.Entry.Revision, Path: filepath.Join(dir, name), Tally: journal.tally().tally()})
+
+	lock, err := readLock(dir)
+	if err != nil {
+		return err
+	}
+	lock.set(target.Entry)
+	err = journal.save(filepath.Join(dir, lockName))
+	if err != nil {
+		return err
+	}
+	err = lock.write(dir)
+	if err != nil {
+		return err
+	}
+
+	limit := flag_history
+	if limit <= 0 {
+		limit = len(states)
+	}
+	target.Time = time.Now().UTC().Truncate(time.Second)
+	history.push(target, limit)
+	return history.write(journal, dir, nil)
+}
+
+// mainRollback restores the smuggled package in dir (see historyTarget) to the state n
+// imports ago (default: 1, the previous one). Either argument may be left out
+func mainRollback(n, dir string) error {
+	count := 1
+	if n != "" {
+		var err error
+		count, err = strconv.Atoi(n)
+		if err != nil {
+			if dir != "" {
+				return fmt.Errorf("rollback: invalid number %q", n)
+			}
+			count, dir = 1, n
+		}
+	}
+	hostDir, name, err := historyTarget(dir)
+	if err != nil {
+		return err
+	}
+	return rollback(hostDir, name, count)
+}
@@ -0,0 +1,134 @@
+package smuggol
+
+import (
+	"bytes"
+	. "github.com/robertkrimen/smuggol/terst"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	Terst(t)
+
+	base, err := ioutil.TempDir("", "smuggol.")
+	Is(err, nil)
+	if err != nil {
+		FailNow()
+	}
+	defer os.RemoveAll(base)
+
+	flag_quiet = true
+	defer func() {
+		flag_quiet = false
+	}()
+
+	write := func(name, content string) string {
+		path := filepath.Join(base, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0777)
+		ioutil.WriteFile(path, []byte(content), 0666)
+		return path
+	}
+	read := func(name string) string {
+		content, _ := ioutil.ReadFile(filepath.Join(base, filepath.FromSlash(name)))
+		return string(content)
+	}
+
+	// An import of v1 (with an extra), then of v2 (without one)
+	paths := []string{
+		write("xyzzy/xyzzy.go", "package xyzzy // v1\n"),
+		write("xyzzy/old.go", "package xyzzy\n"),
+		write("xyzzy.go", "package host // v1\n"),
+	}
+	entry := _lockImport{Name: "xyzzy", Source: "example.com/xyzzy", Version: "v1.0.0"}
+	Is(recordHistory(newJournal(), base, entry, paths, 2), nil)
+
+	os.Remove(filepath.Join(base, "xyzzy", "old.go"))
+	os.Remove(filepath.Join(base, "xyzzy.go"))
+	paths = []string{
+		write("xyzzy/xyzzy.go", "package xyzzy // v2\n"),
+		write("xyzzy/new.go", "package xyzzy\n"),
+	}
+	entry.Version = "v2.0.0"
+	Is(recordHistory(newJournal(), base, entry, paths, 2), nil)
+	// ...again, with nothing new, which is not another state
+	Is(recordHistory(newJournal(), base, entry, paths, 2), nil)
+	lock := &_lock{}
+	lock.set(entry)
+	Is(lock.write(base), nil)
+
+	history, err := readHistory(base)
+	Is(err, nil)
+	states := history.states("xyzzy")
+	Is(len(states), 2)
+	Is(states[0].String(), "example.com/xyzzy@v2.0.0")
+	Is(states[1].String(), "example.com/xyzzy@v1.0.0")
+
+	hostDir, name, err := historyTarget(filepath.Join(base, "xyzzy"))
+	Is(err, nil)
+	Is(hostDir, base)
+	Is(name, "xyzzy")
+	_, name, err = historyTarget(base)
+	Is(err, nil)
+	Is(name, "xyzzy")
+
+	output := &bytes.Buffer{}
+	Is(mainHistory(base, output), nil)
+	Like(output.String(), `0\t.*\texample.com/xyzzy@v2.0.0\t2 files \(current\)`)
+	Like(output.String(), `1\t.*\texample.com/xyzzy@v1.0.0\t3 files\n`)
+
+	Like(rollback(base, "xyzzy", 2), `has 1 earlier state`)
+
+	Is(mainRollback("", filepath.Join(base, "xyzzy")), nil)
+	Is(read("xyzzy/xyzzy.go"), "package xyzzy // v1\n")
+	Is(read("xyzzy/old.go"), "package xyzzy\n")
+	Is(read("xyzzy.go"), "package host // v1\n")
+	_, err = os.Stat(filepath.Join(base, "xyzzy", "new.go"))
+	Is(os.IsNotExist(err), true)
+
+	lock, err = readLock(base)
+	Is(err, nil)
+	Is(lock.get("xyzzy").Version, "v1.0.0")
+
+	// The rollback is the newest state
+	history, err = readHistory(base)
+	Is(err, nil)
+	states = history.states("xyzzy")
+	Is(len(states), 3)
+	Is(states[0].Entry.Version, "v1.0.0")
+
+	// A corrupt object means nothing is restored
+	sum := states[1].Files["xyzzy/xyzzy.go"]
+	ioutil.WriteFile(objectPath(base, sum), []byte("corrupt"), 0666)
+	Like(rollback(base, "xyzzy", 1), `is corrupt`)
+	Is(read("xyzzy/xyzzy.go"), "package xyzzy // v1\n")
+	Is(read("xyzzy/old.go"), "package xyzzy\n")
+
+	// Old states (and their objects) are dropped
+	entry.Version = "v3.0.0"
+	Is(recordHistory(newJournal(), base, entry, []string{write("xyzzy/xyzzy.go", "package xyzzy // v3\n")}, 1), nil)
+	history, err = readHistory(base)
+	Is(err, nil)
+	Is(len(history.states("xyzzy")), 1)
+	objects := 0
+	filepath.Walk(filepath.Join(base, historyDir, "objects"), func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			objects++
+		}
+		return nil
+	})
+	Is(objects, 1)
+
+	// A malformed sum (in history.json) is an error, not a panic
+	Is(validSum(kilt.Sha1([]byte("xyzzy"))), true)
+	Is(validSum(strings.ToUpper(kilt.Sha1([]byte("xyzzy")))), false)
+	path := filepath.Join(base, historyDir, "history.json")
+	content, _ := ioutil.ReadFile(path)
+	sum = history.states("xyzzy")[0].Files["xyzzy/xyzzy.go"]
+	ioutil.WriteFile(path, bytes.Replace(content, []byte(`"`+sum+`"`), []byte(`"a"`), 1), 0666)
+	_, err = readHistory(base)
+	Like(err, `xyzzy/xyzzy\.go \(of xyzzy\) has an invalid SHA-1: "a"`)
+	Like(mainRollback("", base), `invalid SHA-1`)
+}
@@ -0,0 +1,211 @@
+package smuggol
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// _journal remembers the original state of every file (and directory) an import
+// touches, so that the import can be undone
+type _journal struct {
+	saved map[string]*[]byte // Path => original content, or nil if the path did not exist
+	links map[string]string  // Path => original target, if the path was a symlink
+	order []string
+	dirs  []string // Directories created by the import
+
+	output *_output // Where report prints
+}
+
+func newJournal() *_journal {
+	return &_journal{
+		saved: map[string]*[]byte{},
+		links: map[string]string{},
+	}
+}
+
+// save records the content of path, unless it is already recorded.
+// Call it before writing or removing path
+func (self *_journal) save(path string) error {
+	if _, exists := self.saved[path]; exists {
+		return nil
+	}
+	if target, err := os.Readlink(path); err == nil {
+		self.links[path] = target
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		self.saved[path] = nil
+	} else {
+		self.saved[path] = &content
+	}
+	self.order = append(self.order, path)
+	return nil
+}
+
+// writeFile is ioutil.WriteFile, saving the original first. A file that already has
+// exactly content is left untouched (so its modification time stays put). A symlink is
+// replaced, rather than written through
+func (self *_journal) writeFile(path string, content []byte) error {
+	err := self.save(path)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		err := os.Remove(path)
+		if err != nil {
+			return err
+		}
+	} else if current, err := ioutil.ReadFile(path); err == nil && bytes.Equal(current, content) {
+		return nil
+	}
+	return ioutil.WriteFile(path, content, 0666)
+}
+
+// symlink makes path a symlink to target (see kilt.Symlink), saving the original first.
+// A regular file is replaced
+func (self *_journal) symlink(target, path string) error {
+	err := self.save(path)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Lstat(path); err == nil && info.Mode().IsRegular() {
+		err := os.Remove(path)
+		if err != nil {
+			return err
+		}
+	}
+	return kilt.Symlink(target, path, true)
+}
+
+// removeSymlink removes path, if it is a symlink
+func removeSymlink(path string) error {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return os.Remove(path)
+	}
+	return nil
+}
+
+// remove is os.Remove, saving the original first
+func (self *_journal) remove(path string) error {
+	err := self.save(path)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// mkdirAll is os.MkdirAll, remembering which directories were created
+func (self *_journal) mkdirAll(path string) error {
+	missing := []string{}
+	for dir := path; ; {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		missing = append(missing, dir)
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	err := os.MkdirAll(path, 0777)
+	if err != nil {
+		return err
+	}
+	self.dirs = append(self.dirs, missing...)
+	return nil
+}
+
+// change describes what has happened to path (so far), compared with the original: "+" (added),
+// "~" (updated), "-" (removed), "=" (unchanged), or "" if it neither existed nor exists
+func (self *_journal) change(path string) string {
+	original := self.saved[path]
+	current, err := ioutil.ReadFile(path)
+	switch {
+	case err != nil && original == nil:
+		return ""
+	case err != nil:
+		return "-"
+	case original == nil:
+		return "+"
+	case !bytes.Equal(*original, current):
+		return "~"
+	}
+	return "="
+}
+
+// _tally counts the changes made to a set of files
+type _tally struct {
+	unchanged, updated, added, removed int
+}
+
+func (self _tally) String() string {
+	return fmt.Sprintf("%d unchanged, %d updated, %d added, %d removed", self.unchanged, self.updated, self.added, self.removed)
+}
+
+// tally counts the changes made to every file saved so far
+func (self *_journal) tally() _tally {
+	result := _tally{}
+	for _, path := range self.order {
+		switch self.change(path) {
+		case "=":
+			result.unchanged++
+		case "~":
+			result.updated++
+		case "+":
+			result.added++
+		case "-":
+			result.removed++
+		}
+	}
+	return result
+}
+
+// restore puts every saved file back the way it was, and removes any (now empty)
+// directory that was created. It carries on past errors, returning the first
+func (self *_journal) restore() error {
+	var first error
+	record := func(err error) {
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	for index := len(self.order) - 1; index >= 0; index-- {
+		path := self.order[index]
+		content := self.saved[path]
+		// Never write through a symlink (into whatever it points to)
+		record(removeSymlink(path))
+		if target, linked := self.links[path]; linked {
+			err := os.Remove(path)
+			if err != nil && !os.IsNotExist(err) {
+				record(err)
+			}
+			record(os.Symlink(target, path))
+			continue
+		}
+		if content == nil {
+			err := os.Remove(path)
+			if err != nil && !os.IsNotExist(err) {
+				record(err)
+			}
+			continue
+		}
+		record(ioutil.WriteFile(path, *content, 0666))
+	}
+	// Deepest first
+	dirs := append([]string{}, self.dirs...)
+	sort.Slice(dirs, func(i, j int) bool {
+		return len(dirs[i]) > len(dirs[j])
+	})
+	for _, dir := range dirs {
+		os.Remove(dir) // Only succeeds if empty
+	}
+	return first
+}
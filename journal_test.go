@@ -0,0 +1,86 @@
+package smuggol
+
+import (
+	. "github.com/robertkrimen/smuggol/terst"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJournal(t *testing.T) {
+	Terst(t)
+
+	base, err := ioutil.TempDir("", "smuggol.")
+	Is(err, nil)
+	if err != nil {
+		FailNow()
+	}
+	defer os.RemoveAll(base)
+
+	existing := filepath.Join(base, "existing.go")
+	removed := filepath.Join(base, "removed.go")
+	ioutil.WriteFile(existing, []byte("package xyzzy // 1\n"), 0666)
+	ioutil.WriteFile(removed, []byte("package xyzzy\n"), 0666)
+
+	journal := newJournal()
+	Is(journal.mkdirAll(filepath.Join(base, "xyzzy", "nothing")), nil)
+	Is(journal.writeFile(filepath.Join(base, "xyzzy", "nothing", "nothing.go"), []byte("package nothing\n")), nil)
+	Is(journal.writeFile(existing, []byte("package xyzzy // 2\n")), nil)
+	Is(journal.writeFile(existing, []byte("package xyzzy // 3\n")), nil)
+	Is(journal.remove(removed), nil)
+
+	Is(journal.restore(), nil)
+
+	content, err := ioutil.ReadFile(existing)
+	Is(err, nil)
+	Is(string(content), "package xyzzy // 1\n")
+
+	content, err = ioutil.ReadFile(removed)
+	Is(err, nil)
+	Is(string(content), "package xyzzy\n")
+
+	_, err = os.Stat(filepath.Join(base, "xyzzy"))
+	Is(os.IsNotExist(err), true)
+}
+
+func TestJournalTally(t *testing.T) {
+	Terst(t)
+
+	base, err := ioutil.TempDir("", "smuggol.")
+	Is(err, nil)
+	if err != nil {
+		FailNow()
+	}
+	defer os.RemoveAll(base)
+
+	unchanged := filepath.Join(base, "unchanged.go")
+	updated := filepath.Join(base, "updated.go")
+	removed := filepath.Join(base, "removed.go")
+	added := filepath.Join(base, "added.go")
+	ioutil.WriteFile(unchanged, []byte("package xyzzy // 1\n"), 0666)
+	ioutil.WriteFile(updated, []byte("package xyzzy // 1\n"), 0666)
+	ioutil.WriteFile(removed, []byte("package xyzzy\n"), 0666)
+
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	os.Chtimes(unchanged, past, past)
+
+	journal := newJournal()
+	Is(journal.writeFile(unchanged, []byte("package xyzzy // 1\n")), nil)
+	Is(journal.writeFile(updated, []byte("package xyzzy // 2\n")), nil)
+	Is(journal.writeFile(added, []byte("package xyzzy\n")), nil)
+	Is(journal.remove(removed), nil)
+	journal.remove(filepath.Join(base, "nothing.go"))
+
+	info, err := os.Stat(unchanged)
+	Is(err, nil)
+	Is(info.ModTime().Equal(past), true)
+
+	Is(journal.change(unchanged), "=")
+	Is(journal.change(updated), "~")
+	Is(journal.change(added), "+")
+	Is(journal.change(removed), "-")
+	Is(journal.change(filepath.Join(base, "nothing.go")), "")
+	Is(journal.tally().String(), "1 unchanged, 1 updated, 1 added, 1 removed")
+}
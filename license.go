@@ -0,0 +1,325 @@
+package smuggol
+
+import (
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// isLicenseFile reports whether name looks like a license (or notice) file: LICENSE, LICENCE, COPYING,
+// NOTICE, UNLICENSE, and the like (e.g. LICENSE.txt, LICENSE-MIT, COPYING.LESSER)
+func isLicenseFile(name string) bool {
+	name = strings.ToUpper(name)
+	if strings.HasSuffix(name, ".GO") {
+		return false // Source, e.g. license.go
+	}
+	for _, prefix := range []string{"LICENSE", "LICENCE", "COPYING", "NOTICE", "UNLICENSE"} {
+		if strings.HasPrefix(name, prefix) {
+			rest := name[len(prefix):]
+			return rest == "" || rest[0] == '.' || rest[0] == '-' || rest[0] == '_'
+		}
+	}
+	return false
+}
+
+// findLicenses returns the license files for the package in dir: those in dir itself or, if there
+// are none and walk is true, those in the nearest enclosing directory (up to the root of the
+// module or repository)
+func findLicenses(dir string, walk bool) ([]string, error) {
+	srcDirs := map[string]bool{}
+	for _, srcDir := range build.Default.SrcDirs() {
+		srcDirs[filepath.Clean(srcDir)] = true
+	}
+	for {
+		manifest, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		result := []string{}
+		root := false
+		for _, file := range manifest {
+			switch {
+			case file.Name() == ".git" || file.Name() == "go.mod":
+				root = true
+			case !file.IsDir() && isLicenseFile(file.Name()):
+				result = append(result, filepath.Join(dir, file.Name()))
+			}
+		}
+		if len(result) > 0 || !walk || root {
+			return result, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir || srcDirs[parent] {
+			return result, nil
+		}
+		dir = parent
+	}
+}
+
+// _licenseFiles collects the license files enclosing a package while it is being extracted from an
+// archive (git or module zip), so that a package in a subdirectory still gets the license of
+// the repository (or module) as a whole
+type _licenseFiles struct {
+	dir   string                       // The directory of the package, in the archive
+	found map[string]map[string][]byte // Directory => name => content
+}
+
+func newLicenseFiles(dir string) *_licenseFiles {
+	return &_licenseFiles{
+		dir:   path.Clean(dir),
+		found: map[string]map[string][]byte{},
+	}
+}
+
+// want reports whether the file at name (in the archive) is a license file in an enclosing directory
+func (self *_licenseFiles) want(name string) bool {
+	dir := path.Dir(name)
+	if dir == self.dir || !isLicenseFile(path.Base(name)) {
+		return false
+	}
+	return dir == "." || strings.HasPrefix(self.dir, dir+"/")
+}
+
+func (self *_licenseFiles) add(name string, content []byte) {
+	dir := path.Dir(name)
+	if self.found[dir] == nil {
+		self.found[dir] = map[string][]byte{}
+	}
+	self.found[dir][path.Base(name)] = content
+}
+
+// write writes the license files of the nearest enclosing directory into dst, unless
+// dst (the package) already has its own
+func (self *_licenseFiles) write(dst string) error {
+	existing, err := findLicenses(dst, false)
+	if err != nil || len(existing) > 0 {
+		return err
+	}
+	depth := func(dir string) int {
+		if dir == "." {
+			return 0
+		}
+		return strings.Count(dir, "/") + 1
+	}
+	nearest := ""
+	for dir := range self.found {
+		if nearest == "" || depth(dir) > depth(nearest) {
+			nearest = dir
+		}
+	}
+	for name, content := range self.found[nearest] {
+		err := ioutil.WriteFile(filepath.Join(dst, name), content, 0666)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	licenseSpace   = regexp.MustCompile(`[\s*#]+`)
+	licenseVersion = regexp.MustCompile(`^[^.]{0,80}?\bversion (\d+(?:\.\d+)?)`)
+)
+
+// licenseHead is how far into a license file its title (or preamble) is looked for
+const licenseHead = 2000
+
+// licensePhrases are the telltale phrases of the title (or preamble) of each kind of license
+var licensePhrases = []struct {
+	phrase string
+	kind   string
+}{
+	{"gnu affero general public license", "AGPL"},
+	{"gnu lesser general public license", "LGPL"},
+	{"gnu library general public license", "LGPL"},
+	{"gnu general public license", "GPL"},
+	{"mozilla public license", "MPL"},
+	{"eclipse public license", "EPL"},
+	{"apache license", "Apache"},
+	{"free and unencumbered software released into the public domain", "Unlicense"},
+	{"permission is hereby granted, free of charge", "MIT"},
+	{"permission to use, copy, modify, and/or distribute this software for any purpose", "ISC"},
+	{"permission to use, copy, modify, and distribute this software for any purpose", "ISC"},
+	{"redistribution and use in source and binary forms", "BSD"},
+}
+
+// identifyLicense identifies the license in content by its title (or preamble): whichever telltale
+// phrase comes first, near the top. Any mention of another license further on (e.g. the GPL naming the
+// Lesser GPL, or the MPL naming the GPLs) is ignored. It returns an SPDX identifier (e.g. MIT, Apache-2.0,
+// GPL-3.0), or "unknown"
+func identifyLicense(content []byte) string {
+	text := licenseSpace.ReplaceAllString(strings.ToLower(string(content)), " ")
+	head := text
+	if len(head) > licenseHead {
+		head = head[:licenseHead]
+	}
+	kind, at := "", -1
+	for _, candidate := range licensePhrases {
+		if index := strings.Index(head, candidate.phrase); index >= 0 && (at < 0 || index < at) {
+			kind, at = candidate.kind, index+len(candidate.phrase)
+		}
+	}
+	// The version, if any, is in the title (e.g. "Version 2, June 1991"), or the notice (e.g. "either version 3")
+	version := ""
+	if at >= 0 {
+		if match := licenseVersion.FindStringSubmatch(text[at:]); match != nil {
+			version = match[1]
+		}
+	}
+	switch kind {
+	case "AGPL":
+		return "AGPL-3.0"
+	case "LGPL":
+		switch version {
+		case "3":
+			return "LGPL-3.0"
+		case "2":
+			return "LGPL-2.0"
+		}
+		return "LGPL-2.1"
+	case "GPL":
+		switch version {
+		case "3":
+			return "GPL-3.0"
+		case "1":
+			return "GPL-1.0"
+		}
+		return "GPL-2.0"
+	case "MPL":
+		if version == "1.1" || version == "1.0" {
+			return "MPL-" + version
+		}
+		return "MPL-2.0"
+	case "EPL":
+		if version == "1.0" {
+			return "EPL-1.0"
+		}
+		return "EPL-2.0"
+	case "Apache":
+		if version == "2.0" {
+			return "Apache-2.0"
+		}
+	case "Unlicense", "MIT", "ISC":
+		return kind
+	case "BSD":
+		for _, phrase := range []string{"neither the name", "names of its contributors", "name of the copyright holder"} {
+			if strings.Contains(text, phrase) {
+				return "BSD-3-Clause"
+			}
+		}
+		return "BSD-2-Clause"
+	}
+	return "unknown"
+}
+
+// licenseKind identifies the license file at name (with content): NOTICE files are just that
+func licenseKind(name string, content []byte) string {
+	if strings.HasPrefix(strings.ToUpper(name), "NOTICE") {
+		return "NOTICE"
+	}
+	return identifyLicense(content)
+}
+
+// The licenses matched by "copyleft" (in -license-deny)
+var copyleftLicenses = []string{"AGPL-*", "GPL-*", "LGPL-*", "MPL-*", "EPL-*"}
+
+// licenseDenied checks the licenses (file name => license) against the deny patterns, returning
+// a description of the first violation, if any. A pattern is a license (e.g. GPL-3.0), a path.Match
+// pattern (e.g. GPL-*), "copyleft", "unknown" (a license that could not be identified), or
+// "none" (no license at all)
+func licenseDenied(licenses map[string]string, deny []string) (string, bool) {
+	names := []string{}
+	for name, license := range licenses {
+		if license != "NOTICE" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, pattern := range deny {
+		if pattern == "none" && len(names) == 0 {
+			return "no license", true
+		}
+		patterns := []string{pattern}
+		if pattern == "copyleft" {
+			patterns = copyleftLicenses
+		}
+		for _, name := range names {
+			for _, pattern := range patterns {
+				if matched, _ := path.Match(pattern, licenses[name]); matched {
+					return fmt.Sprintf("%s (%s)", licenses[name], name), true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// licenseSummary is the (sorted, unique) licenses, without any NOTICE
+func licenseSummary(licenses map[string]string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, license := range licenses {
+		if license != "NOTICE" && !seen[license] {
+			seen[license] = true
+			result = append(result, license)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// readLicenses reads the license files of source, returning their contents and licenses (both by file name)
+func readLicenses(source *_source) (map[string][]byte, map[string]string, error) {
+	files, err := findLicenses(source.pkg.Dir, source.tmp == "")
+	if err != nil {
+		return nil, nil, err
+	}
+	contents := map[string][]byte{}
+	licenses := map[string]string{}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		name := filepath.Base(file)
+		contents[name] = content
+		licenses[name] = licenseKind(name, content)
+	}
+	return contents, licenses, nil
+}
+
+// writeLicenses writes the license files (contents) into dstPath, removing any license file
+// from the previous import that is no longer there
+func writeLicenses(journal *_journal, dstPath, relativeDstPath string, contents map[string][]byte, licenses map[string]string, previous *_lockImport) error {
+	if previous != nil {
+		for name := range previous.Licenses {
+			if _, exists := contents[name]; !exists {
+				err := journal.remove(filepath.Join(dstPath, name))
+				if err != nil && !os.IsNotExist(err) {
+					return err
+				}
+				journal.report(filepath.Join(dstPath, name), filepath.Join(relativeDstPath, name), previous.Licenses[name])
+			}
+		}
+	}
+	names := []string{}
+	for name := range contents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path := filepath.Join(dstPath, name)
+		err := journal.writeFile(path, contents[name])
+		if err != nil {
+			return err
+		}
+		journal.report(path, filepath.Join(relativeDstPath, name), licenses[name])
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package smuggol
+
+import (
+	. "github.com/robertkrimen/smuggol/terst"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLicense(t *testing.T) {
+	Terst(t)
+
+	Is(isLicenseFile("LICENSE"), true)
+	Is(isLicenseFile("license.txt"), true)
+	Is(isLicenseFile("LICENSE-MIT"), true)
+	Is(isLicenseFile("COPYING.LESSER"), true)
+	Is(isLicenseFile("NOTICE"), true)
+	Is(isLicenseFile("licensed.go"), false)
+	Is(isLicenseFile("license.go"), false)
+	Is(isLicenseFile("license_test.go"), false)
+	Is(isLicenseFile("README"), false)
+
+	// The full texts (or notices), where any other license mentioned in the body is not the license itself
+	for name, license := range map[string]string{
+		"AGPL-3.0-notice": "AGPL-3.0",
+		"Apache-2.0":      "Apache-2.0",
+		"BSD":             "BSD-3-Clause",
+		"BSD-2-Clause":    "BSD-2-Clause",
+		"GPL-1":           "GPL-1.0",
+		"GPL-2":           "GPL-2.0",
+		"GPL-2.0-notice":  "GPL-2.0",
+		"GPL-3":           "GPL-3.0",
+		"ISC":             "ISC",
+		"LGPL-2":          "LGPL-2.0",
+		"LGPL-2.1":        "LGPL-2.1",
+		"LGPL-3":          "LGPL-3.0",
+		"MIT":             "MIT",
+		"MPL-1.1":         "MPL-1.1",
+		"MPL-2.0":         "MPL-2.0",
+		"Unlicense":       "Unlicense",
+	} {
+		content, err := ioutil.ReadFile(filepath.Join("testdata", "license", name))
+		Is(err, nil)
+		Is(identifyLicense(content), license, name)
+	}
+	Is(identifyLicense([]byte("Do what you want.\n")), "unknown")
+	Is(licenseKind("NOTICE", []byte("This product includes software developed at ...\n")), "NOTICE")
+
+	licenses := map[string]string{"LICENSE": "GPL-3.0", "NOTICE": "NOTICE"}
+	violation, denied := licenseDenied(licenses, []string{"copyleft"})
+	Is(denied, true)
+	Is(violation, "GPL-3.0 (LICENSE)")
+	_, denied = licenseDenied(licenses, []string{"GPL-2.0", "unknown"})
+	Is(denied, false)
+	_, denied = licenseDenied(licenses, []string{"GPL-*"})
+	Is(denied, true)
+	gpl, _ := ioutil.ReadFile(filepath.Join("testdata", "license", "GPL-2"))
+	_, denied = licenseDenied(map[string]string{"COPYING": identifyLicense(gpl)}, []string{"GPL-*"})
+	Is(denied, true)
+	violation, denied = licenseDenied(map[string]string{"NOTICE": "NOTICE"}, []string{"none"})
+	Is(denied, true)
+	Is(violation, "no license")
+	_, denied = licenseDenied(map[string]string{"LICENSE": "MIT"}, []string{"none", "copyleft"})
+	Is(denied, false)
+	Is(licenseSummary(map[string]string{"LICENSE": "MIT", "LICENSE-APACHE": "Apache-2.0", "COPYING": "MIT", "NOTICE": "NOTICE"}), []string{"Apache-2.0", "MIT"})
+
+	// The license of the nearest enclosing directory in an archive
+	files := newLicenseFiles("xyzzy/nothing")
+	Is(files.want("LICENSE"), true)
+	Is(files.want("xyzzy/LICENSE"), true)
+	Is(files.want("xyzzy/nothing/LICENSE"), false)
+	Is(files.want("other/LICENSE"), false)
+	Is(files.want("xyzzy/README"), false)
+	files.add("LICENSE", []byte("root"))
+	files.add("xyzzy/LICENSE", []byte("xyzzy"))
+	files.add("xyzzy/NOTICE", []byte("notice"))
+
+	base, err := ioutil.TempDir("", "smuggol.")
+	Is(err, nil)
+	if err != nil {
+		FailNow()
+	}
+	defer os.RemoveAll(base)
+
+	Is(files.write(base), nil)
+	content, err := ioutil.ReadFile(filepath.Join(base, "LICENSE"))
+	Is(err, nil)
+	Is(string(content), "xyzzy")
+	_, err = os.Stat(filepath.Join(base, "NOTICE"))
+	Is(err, nil)
+
+	// The package has its own license, so the enclosing one is left out
+	own := filepath.Join(base, "own")
+	os.Mkdir(own, 0777)
+	ioutil.WriteFile(filepath.Join(own, "COPYING"), []byte("own"), 0666)
+	Is(files.write(own), nil)
+	found, err := findLicenses(own, false)
+	Is(err, nil)
+	Is(found, []string{filepath.Join(own, "COPYING")})
+
+	// findLicenses walks up (to the module root) when the package has none
+	nested := filepath.Join(base, "module", "nested")
+	os.MkdirAll(nested, 0777)
+	ioutil.WriteFile(filepath.Join(base, "module", "go.mod"), []byte("module example.com/module\n"), 0666)
+	ioutil.WriteFile(filepath.Join(base, "module", "LICENSE.txt"), []byte("module"), 0666)
+	found, err = findLicenses(nested, true)
+	Is(err, nil)
+	Is(found, []string{filepath.Join(base, "module", "LICENSE.txt")})
+	found, err = findLicenses(nested, false)
+	Is(err, nil)
+	Is(len(found), 0)
+}
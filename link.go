@@ -0,0 +1,136 @@
+package smuggol
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// With -link, the files of the import package are symlinked (rather than copied) into the
+// subordinate package, for development against a local checkout:
+//
+//      xyzzy/xyzzy.go -> ../../../robertkrimen/xyzzy/xyzzy.go
+//
+// The lock entry records the linked directory until the links are materialized (replaced with copies)
+
+// linkTarget returns what the symlink at path should point to for target: a relative path
+// if there is one, or else target itself
+func linkTarget(path, target string) (string, error) {
+	absolutePath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	absoluteTarget, err := filepath.Abs(target)
+	if err != nil {
+		return "", err
+	}
+	if relative, err := filepath.Rel(filepath.Dir(absolutePath), absoluteTarget); err == nil {
+		return relative, nil
+	}
+	return absoluteTarget, nil
+}
+
+// materialize replaces the symlinks of every linked package (see -link) in the host package
+// in dir with copies (with the generated header), as if they had been copied in the first
+// place. Either every package is materialized, or none is
+func materialize(dir string) (err error) {
+	lock, err := readLock(dir)
+	if err != nil {
+		return err
+	}
+
+	journal := newJournal()
+	defer func() {
+		if err != nil {
+			journal.restore()
+		}
+	}()
+
+	entries := []_lockImport{}
+	for _, entry := range lock.Imports {
+		if entry.Link == "" {
+			continue
+		}
+		dstPath := filepath.Join(dir, entry.Name)
+		header := _header{
+			tool:     entry.Tool,
+			kind:     "from",
+			source:   entry.Source,
+			version:  entry.Version,
+			revision: entry.Revision,
+		}
+		files := []string{}
+		for file := range entry.Files {
+			files = append(files, file)
+		}
+		sort.Strings(files)
+		for _, file := range files {
+			path := filepath.Join(dstPath, file)
+			if info, err := os.Lstat(path); err != nil || info.Mode()&os.ModeSymlink == 0 {
+				return fmt.Errorf("materialize: %s is not a symlink", path)
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			content = append([]byte(header.String()+"\n\n"), content...)
+			err = journal.writeFile(path, content)
+			if err != nil {
+				return err
+			}
+			journal.report(path, path, "")
+			entry.Files[file] = kilt.Sha1(content)
+		}
+		entry.Link = ""
+		lock.set(entry)
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	err = journal.save(filepath.Join(dir, lockName))
+	if err != nil {
+		return err
+	}
+	err = lock.write(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		paths := []string{}
+		for file := range entry.Files {
+			paths = append(paths, filepath.Join(dir, entry.Name, file))
+		}
+		for file := range entry.Licenses {
+			paths = append(paths, filepath.Join(dir, entry.Name, file))
+		}
+		err := recordHistory(journal, dir, entry, paths, flag_history)
+		if err != nil {
+			return err
+		}
+		say("# %s: materialized", filepath.Join(dir, entry.Name))
+		emit(_event{Action: "done", Package: entry.Source, Version: entry.Version, Revision: entry.Revision, Path: filepath.Join(dir, entry.Name)})
+	}
+	return nil
+}
+
+// mainMaterialize materializes every linked package under root
+func mainMaterialize(root string) error {
+	if root == "" {
+		root = "."
+	}
+	dirs, err := findLocks(root)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		err := materialize(dir)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
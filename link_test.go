@@ -0,0 +1,84 @@
+package smuggol
+
+import (
+	. "github.com/robertkrimen/smuggol/terst"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLink(t *testing.T) {
+	Terst(t)
+
+	base, err := ioutil.TempDir("", "smuggol.")
+	Is(err, nil)
+	if err != nil {
+		FailNow()
+	}
+	defer os.RemoveAll(base)
+
+	upstream := filepath.Join(base, "upstream", "xyzzy.go")
+	os.MkdirAll(filepath.Dir(upstream), 0777)
+	ioutil.WriteFile(upstream, []byte("package xyzzy\n"), 0666)
+	path := filepath.Join(base, "host", "xyzzy", "xyzzy.go")
+	os.MkdirAll(filepath.Dir(path), 0777)
+
+	target, err := linkTarget(path, upstream)
+	Is(err, nil)
+	Is(target, filepath.FromSlash("../../upstream/xyzzy.go"))
+
+	// A regular file is replaced by the link, and a link is replaced (not written through)
+	ioutil.WriteFile(path, []byte("package xyzzy // 1\n"), 0666)
+	journal := newJournal()
+	Is(journal.symlink(target, path), nil)
+	Is(journal.change(path), "~")
+	Is(journal.restore(), nil)
+	content, _ := ioutil.ReadFile(path)
+	Is(string(content), "package xyzzy // 1\n")
+
+	journal = newJournal()
+	Is(journal.symlink(target, path), nil)
+	journal = newJournal()
+	Is(journal.writeFile(path, []byte("package xyzzy // 2\n")), nil)
+	content, _ = ioutil.ReadFile(upstream)
+	Is(string(content), "package xyzzy\n")
+	Is(journal.restore(), nil)
+	link, err := os.Readlink(path)
+	Is(err, nil)
+	Is(link, target)
+
+	// Materialize
+	lock := &_lock{}
+	lock.set(_lockImport{
+		Name:   "xyzzy",
+		Source: "example.com/xyzzy",
+		Tool:   "xyzzy-import",
+		Link:   filepath.Dir(upstream),
+		Files:  map[string]string{"xyzzy.go": kilt.Sha1([]byte("package xyzzy\n"))},
+	})
+	Is(lock.write(filepath.Join(base, "host")), nil)
+
+	flag_quiet = true
+	defer func() {
+		flag_quiet = false
+	}()
+	Is(mainMaterialize(base), nil)
+
+	content, _ = ioutil.ReadFile(path)
+	Is(string(content), "// Code generated by xyzzy-import (smuggol) from example.com/xyzzy. DO NOT EDIT.\n\npackage xyzzy\n")
+	info, err := os.Lstat(path)
+	Is(err, nil)
+	Is(info.Mode().IsRegular(), true)
+	lock, err = readLock(filepath.Join(base, "host"))
+	Is(err, nil)
+	Is(lock.get("xyzzy").Link, "")
+	Is(lock.get("xyzzy").Files["xyzzy.go"], kilt.Sha1(content))
+
+	result, err := audit(filepath.Join(base, "host"))
+	Is(err, nil)
+	Is(len(result), 1)
+	if len(result) == 1 {
+		Is(len(result[0].Problems), 0)
+	}
+}
@@ -0,0 +1,89 @@
+package smuggol
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// The lockfile (smuggol.lock, in the host package) records what was smuggled
+// into the host package, and from where
+const lockName = "smuggol.lock"
+
+type _lock struct {
+	Imports []_lockImport `json:"imports"`
+}
+
+type _lockImport struct {
+	Name     string            `json:"name"`               // The directory of the subordinate package, relative to the host package
+	Source   string            `json:"source"`             // The import path of the import package
+	Version  string            `json:"version,omitempty"`  // The requested version, if any
+	Revision string            `json:"revision,omitempty"` // The resolved revision, if known
+	Module   string            `json:"module,omitempty"`   // The module providing the import package, if fetched from a proxy
+	Sum      string            `json:"sum,omitempty"`      // The "h1:" hash of the module, if fetched from a proxy
+	Tool     string            `json:"tool,omitempty"`     // The application that did the import
+	Include  []string          `json:"include,omitempty"`  // The files copied (patterns, see _fileFilter), if not all
+	Exclude  []string          `json:"exclude,omitempty"`  // The files not copied (patterns, see _fileFilter)
+	Build    string            `json:"build,omitempty"`    // The build constraint added to every file, if any
+	Link     string            `json:"link,omitempty"`     // The directory the files are symlinked to (see -link), until materialized
+	Files    map[string]string `json:"files"`              // File name => SHA-1 of the file (as written)
+	Licenses map[string]string `json:"licenses,omitempty"` // License file name => license (e.g. MIT, Apache-2.0, NOTICE)
+}
+
+// readLock reads the lockfile in dir, returning an empty lock if there is none
+func readLock(dir string) (*_lock, error) {
+	self := &_lock{}
+	content, err := os.ReadFile(filepath.Join(dir, lockName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return self, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(content, self)
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: filepath.Join(dir, lockName), Err: err}
+	}
+	return self, nil
+}
+
+// get returns the entry for the subordinate package name, or nil
+func (self *_lock) get(name string) *_lockImport {
+	for index := range self.Imports {
+		if self.Imports[index].Name == name {
+			return &self.Imports[index]
+		}
+	}
+	return nil
+}
+
+// set adds (or replaces) an entry, keeping the entries sorted by name
+func (self *_lock) set(entry _lockImport) {
+	if existing := self.get(entry.Name); existing != nil {
+		*existing = entry
+	} else {
+		self.Imports = append(self.Imports, entry)
+	}
+	sort.Slice(self.Imports, func(i, j int) bool {
+		return self.Imports[i].Name < self.Imports[j].Name
+	})
+}
+
+func (self *_lock) write(dir string) error {
+	// Not escaped for HTML, so that a build constraint (e.g. "debug && !race") reads as written
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "    ")
+	err := encoder.Encode(self)
+	if err != nil {
+		return err
+	}
+	content := buffer.Bytes()
+	if existing, err := os.ReadFile(filepath.Join(dir, lockName)); err == nil && bytes.Equal(existing, content) {
+		return nil
+	}
+	return kilt.WriteAtomicFile(filepath.Join(dir, lockName), bytes.NewReader(content), 0666)
+}
@@ -24,10 +24,19 @@ This package works by iterating through the .go files in a given import package
 them to a subordinate package in the new host package. The files have the following
 comment at the top:
 
-    // This file was AUTOMATICALLY GENERATED by ... (smuggol) from ...
+    // Code generated by ... (smuggol) from ... DO NOT EDIT.
+
+This follows the "Code generated ... DO NOT EDIT." convention, so linters, gopls, and code review tools
+treat the files as generated. The older "This file was AUTOMATICALLY GENERATED ..." header is still
+recognized (when cleaning up, for status, audit, ...).
 
 The name of the subordinate package is the same as the original import package.
 
+Writes are incremental: a file whose content has not changed is left untouched (so its modification time,
+and any editor or build cache, is undisturbed), a previously generated file is only removed if it is no longer
+generated, and a summary of unchanged/updated/added/removed files is printed at the end. In the output, "+"
+is an added file, "~" an updated one, "-" a removed one, and "=" (with -verbose) an unchanged one.
+
 Additionally, supporting .go files can be generated in the host package at the same time. This is
 done via a `map[string]string` , with each key/value pair representing a new file in the host package.
 Before being written to disk, the value is processed through "text/template" as a template with the following
@@ -36,41 +45,245 @@ defined:
     HostPackage     # The name of the host package
     ImportPath      # The import path to the new import package
     ImportPackage   # The name of the new import package
+    SourcePath      # The import path of the original (source) package
+    Revision        # The (git) revision of the source package, if known
+    Files           # The files copied into the new import package
+    Exports         # The exported identifiers of the new import package (.Name, .Kind, .Signature)
+    Funcs           # ...just the funcs (also: Types, Vars, Consts)
+    Time            # When the import happened
+    Tool            # The name of the application doing the import
+
+along with the following functions:
+
+    lower, upper    # strings.ToLower, strings.ToUpper
+    camel           # {{ camel "kilt" "GraveTrim" }} => kiltGraveTrim
+    join            # strings.Join
+    quote           # strconv.Quote
+    GraveTrim       # Trim leading/trailing whitespace from a `...` string
+
+With -shim, a forwarding file (<package>_shim.go) is generated in the host package as well, aliasing
+each exported func, const, and type of the import package to an unexported identifier:
+
+    var kiltGraveTrim = kilt.GraveTrim
+
+Use -shim-include and -shim-exclude (comma-separated patterns, e.g. "Sha1*") to pick what is forwarded.
+
+Instead of a `map[string]string`, the extra files can also come from an fs.FS (with MainFS, e.g. an
+embed.FS, or os.DirFS for a directory), or from the -templates flag. Each *.tmpl file becomes a file in the host package
+(minus the .tmpl suffix, subdirectories preserved), while a file beginning with "_" is a partial, only
+available via {{ template "_partial.go.tmpl" . }}. A template can start with front matter that decides
+whether it is generated at all:
+
+    ---
+    if: .Funcs
+    ---
+    package {{ .HostPackage }}
+
+Instead of naming the import package up front, the host package can declare its own smuggol imports
+with a directive comment (much like //go:generate), which makes for a nice fit with "go generate":
+
+    //go:generate smuggol
+    //smuggol:import github.com/robertkrimen/terst
+    //smuggol:import github.com/robertkrimen/dbg as debug
+
+The optional "as <name>" renames the subordinate package (and its directory).
+
+An import can be pinned to an exact version (a tag or commit) with <package>@<version>, or with -version.
+The package is then taken, as of that revision, from a local git repository (the one containing the package,
+or the one given by -repo) without fetching anything. The revision is recorded in the generated header, and
+every import is recorded in a lockfile (smuggol.lock) in the host package.
+
+With -proxy, packages are instead downloaded directly from a module proxy (GOPROXY protocol), without the
+go command: either https://... or file://... (e.g. file://$GOPATH/pkg/mod/cache/download) for hermetic,
+offline use. The module zip is verified against its "h1:" hash in smuggol.lock and go.sum, when known.
+
+With -verify, the import package and the host package are type-checked after the import, and every type
+error is reported (file:line). With -rollback, a failed import (including a failed verification) is undone,
+restoring every file to its previous state.
+
+With -prune, the import package is tree-shaken down to what the host package actually uses (as determined by
+go/types): unreachable funcs, types (and their methods), vars, consts, and imports are dropped.
+
+The status command lists every smuggled package (found via smuggol.lock) under a directory, along with its
+recorded version/revision, any newer upstream versions (from the local git repository, or the module cache
+or -proxy), and a per-file summary of what an update would bring in. Use -json for JSON output.
 
+With -apidiff, nothing is imported. Instead, the exported API of the package currently smuggled is compared with
+the incoming one (funcs, method sets, struct fields, consts, ...), each change is classified as compatible (+) or
+breaking (!), and every breaking change is cross-referenced with the lines of the host package that use it.
+The exit status is non-zero (a policy violation) if any breaking change affects the host package. With -json,
+each change is an apidiff event.
+
+The audit command finds every generated file under a directory (by its header) and every smuggol.lock, and
+takes an inventory grouped by directory, origin, and tool. Orphaned files (no lock entry), half-updated
+packages (mixed origins, or a header that disagrees with the lock), and modified, untracked, or missing files
+are flagged, and the exit status is non-zero if there are any. Use -json for JSON output.
+
+License files (LICENSE, LICENCE, COPYING, NOTICE, ...) are copied along with the package: those in the
+package directory or, if there are none, those of the nearest enclosing directory up to the root of the
+module or repository. Each is identified (MIT, BSD-2-Clause, BSD-3-Clause, Apache-2.0, ISC, MPL-2.0,
+GPL-2.0, GPL-3.0, LGPL-*, AGPL-3.0, ...) by its text and recorded in smuggol.lock (and reported by audit).
+With -license-deny, an import is refused if any license matches one of the given (comma-separated)
+patterns: a license (GPL-3.0), a pattern (GPL-*), "copyleft", "unknown", or "none" (no license at all).
+
+A policy file (smuggol.policy, JSON, found in the host package or a directory above it up to the root of the
+module or repository, or given by -policy) sets guardrails on what may be smuggled: the allowed source path
+prefixes ("allow"), forbidden packages ("deny"), the maximum size of the .go files ("maxSize"), and
+prohibited imports in smuggled code ("imports", e.g. "unsafe", "os/exec", "net/...", or "cgo"). The policy
+is checked before anything is written, and an import is refused if there is any violation (each is
+reported, with its position).
+
+Every import also records the state of the package (its files, license files, extras, and lockfile entry) in a
+bounded history in the host package: .smuggol/history.json, with the content of each file stored by SHA-1 in
+.smuggol/objects. -history sets how many states are kept (default 10, 0 for none). The history command lists
+them, and "rollback [n] [directory]" restores the state n imports ago (default 1): every file is restored, or
+none is. A rollback is itself recorded, so rolling back twice undoes the rollback.
+
+With -include and -exclude (comma-separated patterns: globs, or a regular expression between slashes), only
+some of the files of the import package are copied. The same can be given per package, as include= and
+exclude= options of a directive (//smuggol:import <path> exclude=debug_*.go). The patterns are recorded in
+smuggol.lock, and the remaining files are type-checked first, to make sure they still form a complete package.
+
+With -build, a build constraint (a //go:build expression, e.g. "debug && !race") is added to every generated
+file: the copied files, and any .go extras. It is combined (with &&) with any constraint a file already has,
+and written both as //go:build and as // +build lines, after the generated header. The same can be given per
+package, as a build= option of a directive (without spaces, e.g. build=debug&&!race).
+
+Transformers (see Transform) rewrite the files of the import package as they are smuggled, between
+reading and writing them: each gets the parsed file (with type information, if the package type-checks).
+There are a few built in: Rename (a package-level identifier), ReplaceImport, and StripInit.
+
+With -link, the files of the import package are symlinked (relative where possible) into the subordinate
+package instead of copied, for development against a local checkout (in GOPATH, or a directory) that is being
+edited. The lock entry records the linked directory, and audit reports it. Before committing, "materialize
+[directory]" replaces the links with copies, as if they had been copied in the first place.
+
+With -watch, the import runs again whenever a .go file of a local import package (in GOPATH, or a directory)
+changes, until interrupted. The directories are polled (every second, or -watch-every), and a burst of writes is
+waited out before importing again. Each time, the files that changed are printed, along with what the import did.
+
+With -json, an import prints one JSON event per line (and nothing else) on standard output, for a program to
+parse: fetch, resolve, add, update, remove, unchanged (with the path and SHA-1 of each file), template, format,
+verify (with any type errors), apidiff (with -apidiff), done (with a tally), and error. Whatever go get prints
+goes to standard error.
+
+If anything goes wrong, the exit status says what kind of thing it was: 3 (no host package), 4 (the import
+package was not found), 5 (it could not be fetched), 6 (a template error), 7 (drift: what is there does not match
+smuggol.lock or go.sum), 8 (a policy violation), 9 (type errors, with -verify or a filter), 10 (a conflict:
+two directives import into the same subordinate package), or else 1. The same errors (ErrNoHostPackage, etc.)
+can be checked with errors.Is, from Run.
+
+With more than one directive, the imports run concurrently (at most -jobs at once, the number of CPUs by
+default): each is resolved, fetched, transformed, and written alongside the others, and then each, in order,
+takes its turn at the host package itself (extras, the shim, smuggol.lock, ...). What each prints is held back
+and printed in order, so the result is the same as one after another: after a failure, the imports before it
+are done, and the ones after it are undone. Two imports into the same subordinate package are a conflict.
+
+When directives import the same package at more than one version (from different files), each version is
+smuggled side by side into a subordinate package of its own, named for the version (e.g. terstv1 and terstv2,
+or terst_v1_2_0 if the major version is not enough), with its own entry in smuggol.lock: entries are keyed by
+the subordinate package, so the name (with the version in it) tells apart two versions of the same source. The
+file with the directive is the consumer of that version: its import of the package is rewritten to point at the
+copy (keeping the name it is used by). With -versioned, a single import is named for its version as well.
 */
 package smuggol
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	Flag "flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"text/template"
+	"time"
 )
 
 var (
-	flag         = Flag.NewFlagSet("", Flag.ExitOnError)
-	flag_update  = false
-	flag_verbose = false
-	flag_quiet   = false
-	_            = func() byte {
+	flag             = Flag.NewFlagSet("", Flag.ExitOnError)
+	flag_update      = false
+	flag_verbose     = false
+	flag_quiet       = false
+	flag_shim        = false
+	flag_shimInclude = ""
+	flag_shimExclude = ""
+	flag_templates   = ""
+	flag_repo        = ""
+	flag_version     = ""
+	flag_versioned   = false
+	flag_proxy       = ""
+	flag_verify      = false
+	flag_rollback    = false
+	flag_prune       = false
+	flag_json        = false
+	flag_apidiff     = false
+	flag_licenseDeny = ""
+	flag_policy      = ""
+	flag_history     = 10
+	flag_include     = ""
+	flag_exclude     = ""
+	flag_build       = ""
+	flag_link        = false
+	flag_watch       = false
+	flag_watchEvery  = time.Second
+	flag_jobs        = runtime.NumCPU()
+	_                = func() byte {
 		flag.BoolVar(&flag_update, "update", flag_update, "Update (go get -u) package first")
-		flag.BoolVar(&flag_update, "u", flag_update, string(0))
+		flag.BoolVar(&flag_update, "u", flag_update, "\x00")
 
 		flag.BoolVar(&flag_verbose, "verbose", flag_verbose, "Be more verbose")
-		flag.BoolVar(&flag_verbose, "v", flag_verbose, string(0))
+		flag.BoolVar(&flag_verbose, "v", flag_verbose, "\x00")
 
 		flag.BoolVar(&flag_quiet, "quiet", flag_quiet, "Be absolutely quiet")
-		flag.BoolVar(&flag_quiet, "q", flag_quiet, string(0))
+		flag.BoolVar(&flag_quiet, "q", flag_quiet, "\x00")
+
+		flag.BoolVar(&flag_json, "json", flag_json, "Output JSON (for status and audit), or one JSON event per line (for an import)")
+
+		flag.StringVar(&flag_version, "version", flag_version, "Import the package as of this version (a tag or commit)")
+		flag.BoolVar(&flag_versioned, "versioned", flag_versioned, "Name the subordinate package for the version (e.g. terstv2), to smuggle more than one version side by side")
+		flag.StringVar(&flag_proxy, "proxy", flag_proxy, "Download packages from this module proxy (https://... or file://...) instead of using \"go get\"")
+		flag.StringVar(&flag_repo, "repo", flag_repo, "The local git repository to take a pinned (<package>@<revision>) import from")
+
+		flag.BoolVar(&flag_verify, "verify", flag_verify, "Type-check the import (and host) package afterwards")
+		flag.BoolVar(&flag_apidiff, "apidiff", flag_apidiff, "Report on API changes between the current import and the incoming one, without importing")
+		flag.BoolVar(&flag_prune, "prune", flag_prune, "Drop whatever in the import package is not used by the host package")
+		flag.BoolVar(&flag_rollback, "rollback", flag_rollback, "Undo the import if it fails (implies -verify)")
+		flag.StringVar(&flag_policy, "policy", flag_policy, "The policy file to check imports against (default: the nearest "+policyName+")")
+		flag.StringVar(&flag_licenseDeny, "license-deny", flag_licenseDeny, "Refuse to import a package with any of these (comma-separated) licenses: e.g. GPL-*, copyleft, unknown, none")
+
+		flag.IntVar(&flag_history, "history", flag_history, "Keep this many states of each smuggled package (in "+historyDir+"), for rollback (0 to keep none)")
+
+		flag.StringVar(&flag_include, "include", flag_include, "Only copy the files matching these (comma-separated) patterns: globs, or /regexp/")
+		flag.StringVar(&flag_exclude, "exclude", flag_exclude, "Do not copy the files matching these (comma-separated) patterns: globs, or /regexp/")
+
+		flag.StringVar(&flag_build, "build", flag_build, "Add this build constraint (a //go:build expression, e.g. debug) to every generated file")
+
+		flag.BoolVar(&flag_link, "link", flag_link, "Symlink the files of a local import package instead of copying them (see materialize)")
+		flag.BoolVar(&flag_watch, "watch", flag_watch, "Import again whenever a .go file of a local import package changes (until interrupted)")
+		flag.DurationVar(&flag_watchEvery, "watch-every", flag_watchEvery, "How often -watch checks for changes")
+
+		flag.IntVar(&flag_jobs, "jobs", flag_jobs, "How many imports (from directives) to work on at once")
+
+		flag.StringVar(&flag_templates, "templates", flag_templates, "A directory of *.tmpl files to generate in the host package")
+
+		flag.BoolVar(&flag_shim, "shim", flag_shim, "Generate a forwarding shim (<package>_shim.go) in the host package")
+		flag.StringVar(&flag_shimInclude, "shim-include", flag_shimInclude, "Only forward identifiers matching these (comma-separated) patterns")
+		flag.StringVar(&flag_shimExclude, "shim-exclude", flag_shimExclude, "Do not forward identifiers matching these (comma-separated) patterns")
 		return 0
 	}()
 
-	mainName = ""
-	mainPkg  = ""
+	mainName         = ""
+	mainPkg          = ""
+	mainTransformers = []Transformer{} // See Transform
+	mainWatch        *_watch           // With -watch
 
 	_gofmt = true
 )
@@ -79,16 +292,29 @@ var (
 // smuggol: github.com/robertkrimen/kilt.GraveTrim =>
 // github.com/robertkrimen/kilt/kilt.GraveTrim.go
 
-func get(pkg string) error {
+// getLock keeps concurrent imports (see mainDirective) from running go get at the same time,
+// in the same GOPATH
+var getLock sync.Mutex
+
+func get(ctx context.Context, pkg string) error {
+	getLock.Lock()
+	defer getLock.Unlock()
+
+	output := outputOf(ctx)
 	arguments := []string{"get", "-u", "-v", pkg}
 	if !flag_update {
 		arguments = append(arguments[:1], arguments[2:]...)
 	}
-	cmd := exec.Command("go", arguments...)
+	cmd := exec.CommandContext(ctx, "go", arguments...)
+	output.say("# go get %s", pkg)
+	output.emit(_event{Action: "fetch", Package: pkg})
 	if !flag_quiet {
-		fmt.Fprintf(os.Stdout, "# go get %s\n", pkg)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+		// With -json, standard output is only for events
+		cmd.Stdout = output.out()
+		if flag_json {
+			cmd.Stdout = output.err()
+		}
+		cmd.Stderr = output.err()
 	}
 	return cmd.Run()
 }
@@ -100,10 +326,113 @@ func relative(base, path string) (relativeBase, relativePath string) {
 	return
 }
 
-func main(dst string, src string, extra map[string]string) error {
+// _import is a single smuggol import: the package at src is copied into a
+// subordinate package of the host package at dst
+type _import struct {
+	dst       string
+	src       string
+	name      string      // The name of the subordinate package (default: the name of the import package)
+	extra     interface{} // See loadTemplates
+	templates string      // A directory of templates, in addition to extra
+
+	versioned bool     // Name the subordinate package for the version (see versionSuffix)
+	suffix    string   // ...or else, append this to the name
+	consumers []string // Files of the host package to point at the subordinate package (see rewriteConsumers)
+
+	verify   bool // Type-check the import (and host) package afterwards
+	rollback bool // Undo the import if anything goes wrong (including verification)
+	prune    bool // Drop whatever in the import package is not used by the host package
+	apidiff  bool // Report on API changes (instead of importing)
+
+	licenseDeny []string // Refuse to import a package with any of these licenses (see licenseDenied)
+	policy      string   // The policy file (default: the nearest smuggol.policy, see findPolicy)
+	history     int      // Keep this many states of the package (see recordHistory)
+
+	include []string // Only copy the files matching these patterns (see _fileFilter)
+	exclude []string // Do not copy the files matching these patterns
+	build   string   // A build constraint to add to every generated file (see addConstraint)
+
+	transformers []Transformer // Rewrite every file of the import package (see Transform)
+	link         bool          // Symlink the files of the import package, instead of copying them
+	watch        *_watch       // Watch the import package (if local) for changes
+
+	shim        bool // Generate a forwarding shim in the host package
+	shimInclude []string
+	shimExclude []string
+
+	turn *_turn // Where the import waits its turn, when concurrent with others (see mainDirective)
+}
+
+// newImport returns an _import configured from the command-line flags
+func newImport(dst, src string, extra interface{}) _import {
+	return _import{
+		dst:          dst,
+		src:          src,
+		extra:        extra,
+		templates:    flag_templates,
+		versioned:    flag_versioned,
+		verify:       flag_verify || flag_rollback,
+		rollback:     flag_rollback,
+		prune:        flag_prune,
+		apidiff:      flag_apidiff,
+		licenseDeny:  splitList(flag_licenseDeny),
+		policy:       flag_policy,
+		history:      flag_history,
+		include:      splitList(flag_include),
+		exclude:      splitList(flag_exclude),
+		build:        flag_build,
+		transformers: mainTransformers,
+		link:         flag_link,
+		watch:        mainWatch,
+		shim:         flag_shim,
+		shimInclude:  splitList(flag_shimInclude),
+		shimExclude:  splitList(flag_shimExclude),
+	}
+}
+
+func main(dst string, src string, extra interface{}) error {
+	return newImport(dst, src, extra).run(context.Background())
+}
+
+// run performs the import. Whatever it prints goes to the output of ctx (see withOutput), and
+// it stops (undoing whatever it did) if ctx is cancelled while it waits its turn
+func (self _import) run(ctx context.Context) (err error) {
+
+	dst, src := self.dst, self.src
+	output := outputOf(ctx)
 
-	// We ignore the error because buildImport(src) below will barf, if necessary
-	get(src)
+	journal := newJournal()
+	journal.output = output
+	defer func() {
+		switch {
+		case err == nil:
+		case errors.Is(err, context.Canceled):
+			// Another import failed first (see mainDirective), so this one never happened
+			journal.restore()
+		case self.rollback && len(journal.order) > 0:
+			if err := journal.restore(); err != nil {
+				fmt.Fprintf(output.err(), "%s: unable to roll back: %s\n", mainName, err)
+			} else if !flag_quiet {
+				fmt.Fprintf(output.err(), "%s: rolled back %s\n", mainName, src)
+			}
+		}
+	}()
+
+	extra, err := loadTemplates(self.extra)
+	if err != nil {
+		return fail(ErrTemplate, err)
+	}
+	{
+		templates, err := loadTemplates(self.templates)
+		if err != nil {
+			return fail(ErrTemplate, err)
+		}
+		extra = append(extra, templates...)
+	}
+	root, err := parseTemplates(extra)
+	if err != nil {
+		return fail(ErrTemplate, err)
+	}
 
 	if dst == "" {
 		dst = "."
@@ -113,40 +442,160 @@ func main(dst string, src string, extra map[string]string) error {
 	dstBase := dst
 	dstName := ""
 	if err != nil {
-		if len(extra) > 0 {
+		if len(extra) > 0 || self.shim {
 			if !flag_quiet {
-				fmt.Fprintf(os.Stderr, "%s: unable to continue while missing Go package (in %s)\n", mainName, dst)
+				fmt.Fprintf(output.err(), "%s: unable to continue while missing Go package (in %s)\n", mainName, dst)
 			}
-			return err
+			return fail(ErrNoHostPackage, err)
 		}
 	} else {
 		dstBase = dstPkg.Dir
 		dstName = dstPkg.Name
 	}
 
-	srcPkg, err := buildImport(src)
+	lock, err := readLock(dstBase)
+	if err != nil {
+		return err
+	}
+
+	source, err := resolve(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer source.cleanup()
+	srcPkg := source.pkg
+	if source.tmp == "" {
+		self.watch.add(srcPkg.Dir)
+	}
+	output.emit(_event{Action: "resolve", Package: source.path, Version: source.version, Revision: source.revision, Dir: srcPkg.Dir})
+
+	if source.sum != "" {
+		err := verifySum(source, lock, dstBase)
+		if err != nil {
+			return err
+		}
+	}
+
+	build, err := parseConstraint(self.build)
+	if err != nil {
+		return err
+	}
+
+	filter, err := newFileFilter(self.include, self.exclude)
+	if err != nil {
+		return err
+	}
+	if filter != nil {
+		files := filter.filter(srcPkg.GoFiles)
+		err := checkFiltered(ctx, source.path, srcPkg.Dir, srcPkg.GoFiles, files)
+		if err != nil {
+			return err
+		}
+		srcPkg.GoFiles = files
+	}
+
+	header := _header{
+		tool:     mainName,
+		kind:     "from",
+		source:   source.path,
+		version:  source.version,
+		revision: source.revision,
+	}
+	entry := _lockImport{
+		Source:   source.path,
+		Version:  source.version,
+		Revision: source.revision,
+		Module:   source.module,
+		Sum:      source.sum,
+		Tool:     mainName,
+		Include:  self.include,
+		Exclude:  self.exclude,
+		Build:    self.build,
+		Files:    map[string]string{},
+	}
+
+	name := self.name
+	if name == "" {
+		name = srcPkg.Name
+		suffix := self.suffix
+		if self.versioned && suffix == "" {
+			if source.version == "" {
+				return fmt.Errorf("-versioned: %s has no version", source)
+			}
+			suffix = versionSuffix(source.version, true)
+		}
+		name += suffix
+	}
+
+	entry.Name = name
+	dstPath := filepath.Join(dstBase, name)
+
+	if self.link {
+		switch {
+		case source.tmp != "":
+			return fmt.Errorf("-link: %s is not a local package (in GOPATH, or a directory)", source)
+		case name != srcPkg.Name:
+			return fmt.Errorf("-link: unable to rename package %s (to %s)", srcPkg.Name, name)
+		case self.build != "" || len(self.transformers) > 0 || self.prune:
+			return fmt.Errorf("-link: unable to change the files (with -build, -prune, or a Transformer)")
+		}
+		entry.Link = srcPkg.Dir
+	}
+
+	if self.apidiff {
+		return apiDiffImport(ctx, header.origin(), dstPath, srcPkg, dstPkg, dstName != "")
+	}
+
+	licenseContents, licenses, err := readLicenses(source)
+	if err != nil {
+		return err
+	}
+	if violation, denied := licenseDenied(licenses, self.licenseDeny); denied {
+		return fail(ErrPolicy, fmt.Errorf("%s: %s is denied by -license-deny", source, violation))
+	}
+
+	err = checkPolicy(ctx, dstBase, self.policy, source)
+	if err != nil {
+		return err
+	}
+
+	var transformed map[string][]byte
+	if len(self.transformers) > 0 {
+		transformed, err = transform(source.path, srcPkg.Dir, srcPkg.GoFiles, self.transformers)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = self.turn.claim(ctx, dstPath)
 	if err != nil {
 		return err
 	}
 
-	dstPath := filepath.Join(dstBase, srcPkg.Name)
-	err = os.Mkdir(dstPath, 0777)
-	if err != nil && !os.IsExist(err) {
+	err = journal.mkdirAll(dstPath)
+	if err != nil {
 		return err
 	}
 
 	relativeDstBase, relativeDstPath := relative(dstBase, dstPath)
 
+	// Previously generated files are only removed (after copying) if they are not generated again
+	stale := []string{}
 	{
 		manifest, err := ioutil.ReadDir(dstPath)
 		if err == nil {
-			buffer := make([]byte, 128)
+			buffer := make([]byte, 512)
 			for _, file := range manifest {
-				buffer = buffer[0:128]
+				buffer = buffer[0:512]
 				if file.IsDir() {
 					continue
 				}
 				name := file.Name()
+				if file.Mode()&os.ModeSymlink != 0 {
+					// From -link
+					stale = append(stale, name)
+					continue
+				}
 				path := filepath.Join(dstPath, name)
 				file, err := os.Open(path)
 				if err != nil {
@@ -157,42 +606,102 @@ func main(dst string, src string, extra map[string]string) error {
 					continue
 				}
 				buffer = buffer[:count]
-				if bytes.Contains(buffer, []byte("This file was AUTOMATICALLY GENERATED")) {
-					if flag_verbose {
-						fmt.Fprintf(os.Stdout, "- %s\n", filepath.Join(relativeDstPath, name))
-					}
-					os.Remove(path)
+				if isGenerated(buffer) {
+					stale = append(stale, name)
 				}
 			}
 		}
 	}
 
 	for _, file := range srcPkg.GoFiles {
-		if !flag_quiet {
-			fmt.Fprintf(os.Stdout, "+ %s\n", filepath.Join(relativeDstPath, file))
+		if self.link {
+			path := filepath.Join(dstPath, file)
+			target, err := linkTarget(path, filepath.Join(srcPkg.Dir, file))
+			if err != nil {
+				return err
+			}
+			err = journal.symlink(target, path)
+			if err != nil {
+				return err
+			}
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			journal.report(path, filepath.Join(relativeDstPath, file), "")
+			entry.Files[file] = kilt.Sha1(content)
+			continue
 		}
 
-		srcFile, err := os.Open(filepath.Join(srcPkg.Dir, file))
+		content, err := ioutil.ReadFile(filepath.Join(srcPkg.Dir, file))
 		if err != nil {
 			return err
 		}
-		defer srcFile.Close()
+		if transformed != nil {
+			content = transformed[file]
+			output.emit(_event{Action: "format", Path: filepath.Join(relativeDstPath, file)})
+		}
+
+		if name != srcPkg.Name {
+			content, err = renamePackage(content, name)
+			if err != nil {
+				return fmt.Errorf("%s: %s", filepath.Join(srcPkg.Dir, file), err)
+			}
+		}
+
+		content = append([]byte(header.String()+"\n\n"), content...)
+		content, err = addConstraint(content, build)
+		if err != nil {
+			return fmt.Errorf("%s: %s", filepath.Join(srcPkg.Dir, file), err)
+		}
 
-		dstFile, err := os.Create(filepath.Join(dstPath, file))
+		err = journal.writeFile(filepath.Join(dstPath, file), content)
 		if err != nil {
 			return err
 		}
-		defer dstFile.Close()
+		journal.report(filepath.Join(dstPath, file), filepath.Join(relativeDstPath, file), "")
+		entry.Files[file] = kilt.Sha1(content)
+	}
+
+	for _, file := range stale {
+		if _, exists := entry.Files[file]; exists {
+			continue
+		}
+		path := filepath.Join(dstPath, file)
+		err := journal.remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		journal.report(path, filepath.Join(relativeDstPath, file), "")
+	}
+
+	err = writeLicenses(journal, dstPath, relativeDstPath, licenseContents, licenses, lock.get(name))
+	if err != nil {
+		return err
+	}
+	if len(licenses) > 0 {
+		entry.Licenses = licenses
+	}
 
-		fmt.Fprintf(dstFile, "// This file was AUTOMATICALLY GENERATED by %s (smuggol) from %s\n\n", mainName, mainPkg)
+	// From here on, the host package itself (and smuggol.lock) is touched, so concurrent
+	// imports take turns, in order. Another import may have changed smuggol.lock in the meantime
+	err = self.turn.wait(ctx)
+	if err != nil {
+		return err
+	}
+	lock, err = readLock(dstBase)
+	if err != nil {
+		return err
+	}
 
-		_, err = io.Copy(dstFile, srcFile)
+	if len(self.consumers) > 0 && dstName != "" {
+		err := rewriteConsumers(journal, self.consumers, lock, dstPkg, source, name)
 		if err != nil {
 			return err
 		}
 	}
 
-	if len(extra) > 0 {
+	if len(extra) > 0 || self.shim {
 		importPkg, err := buildImport(dstPath)
 		if err != nil {
 			return err
@@ -204,35 +713,170 @@ func main(dst string, src string, extra map[string]string) error {
 			importPath = "." + string(filepath.Separator) + importPkg.Name
 		}
 
-		data := map[string]string{
-			"HostPackage":   dstName,
-			"ImportPath":    importPath,
-			"ImportPackage": importPkg.Name,
+		checked, err := typeCheck(importPath, dstPath, importPkg.GoFiles)
+		if err != nil {
+			return err
 		}
 
-		for name, tmpl := range extra {
-			if !flag_quiet {
-				fmt.Fprintf(os.Stdout, "+ %s\n", filepath.Join(relativeDstBase, name))
+		data := templateData{
+			HostPackage:   dstName,
+			ImportPath:    importPath,
+			ImportPackage: importPkg.Name,
+			SourcePath:    source.path,
+			Revision:      source.revision,
+			Files:         srcPkg.GoFiles,
+			Exports:       exports(checked),
+			Time:          time.Now(),
+			Tool:          mainName,
+		}
+
+		render := func(name string, tmpl *template.Template, data templateData) error {
+			path := filepath.Join(dstBase, filepath.FromSlash(name))
+			err := journal.mkdirAll(filepath.Dir(path))
+			if err != nil {
+				return err
+			}
+
+			output.emit(_event{Action: "template", Path: filepath.Join(relativeDstBase, name), Template: tmpl.Name()})
+			var buffer bytes.Buffer
+			if !strings.HasSuffix(name, ".go") {
+				err = tmpl.Execute(&buffer, data)
+			} else {
+				formatted := false
+				formatted, err = fmtPipe(func(output io.Writer) error {
+					header := header
+					header.kind = "for"
+					fmt.Fprintf(output, "%s\n\n", header)
+					return tmpl.Execute(output, data)
+				}, &buffer)
+				if formatted && err == nil {
+					output.emit(_event{Action: "format", Path: filepath.Join(relativeDstBase, name)})
+				}
+			}
+			if err != nil {
+				return fail(ErrTemplate, err)
+			}
+			content := buffer.Bytes()
+			if strings.HasSuffix(name, ".go") {
+				content, err = addConstraint(content, build)
+				if err != nil {
+					return fmt.Errorf("%s: %s", name, err)
+				}
 			}
 
-			file, err := os.Create(filepath.Join(dstBase, name))
+			err = journal.writeFile(path, content)
 			if err != nil {
 				return err
 			}
+			journal.report(path, filepath.Join(relativeDstBase, name), "")
+			return nil
+		}
 
-			tmpl, err := template.New("").Parse(kiltGraveTrim(tmpl))
+		for _, tmpl := range extra {
+			skip, err := tmpl.skip(root, data)
+			if err != nil {
+				return fail(ErrTemplate, err)
+			}
+			if skip {
+				continue
+			}
+			err = render(tmpl.name, root.Lookup(tmpl.source), data)
 			if err != nil {
 				return err
 			}
+		}
 
-			err = fmtPipe(func(output io.Writer) error {
-				fmt.Fprintf(output, "// This file was AUTOMATICALLY GENERATED by %s (smuggol) for %s\n\n", mainName, mainPkg)
-				return tmpl.Execute(output, data)
-			}, file)
+		if self.shim {
+			shim := data
+			shim.Exports, err = shimExports(data.Exports, self.shimInclude, self.shimExclude)
 			if err != nil {
 				return err
 			}
+			name := shimName(importPkg.Name)
+			tmpl, err := template.New(name).Funcs(templateFuncMap).Parse(kiltGraveTrim(shimTemplate))
+			if err != nil {
+				return fail(ErrTemplate, err)
+			}
+			if len(shim.Exports) == 0 {
+				err := journal.remove(filepath.Join(dstBase, name))
+				if err != nil && !os.IsNotExist(err) {
+					return err
+				}
+				journal.report(filepath.Join(dstBase, name), filepath.Join(relativeDstBase, name), "")
+				output.say("# %s: nothing to forward", filepath.Join(relativeDstBase, name))
+			} else {
+				err := render(name, tmpl, shim)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if self.prune {
+		if dstName == "" {
+			return fail(ErrNoHostPackage, fmt.Errorf("unable to prune while missing Go package (in %s)", dst))
+		}
+		importPath := dstPath
+		if importPkg, err := buildImport(dstPath); err == nil {
+			importPath = importPkg.ImportPath
+			if importPath == "." {
+				// import "./<importPkg.Name>"
+				importPath = "." + string(filepath.Separator) + importPkg.Name
+			}
 		}
+		result, err := pruneImport(journal, importPath, dstPath, dstPkg.ImportPath, dstPkg.Dir)
+		if err != nil {
+			return err
+		}
+		for _, file := range result.removed {
+			output.say("- %s", filepath.Join(relativeDstPath, file))
+			output.emit(_event{Action: "remove", Path: filepath.Join(relativeDstPath, file)})
+			delete(entry.Files, file)
+		}
+		for file, content := range result.files {
+			entry.Files[file] = kilt.Sha1(content)
+		}
+		output.say("# prune %s: %s", relativeDstPath, result)
+	}
+
+	output.say("# %s: %s", relativeDstPath, journal.tally())
+	output.emit(_event{Action: "done", Package: source.path, Version: source.version, Revision: source.revision, Path: relativeDstPath, Tally: journal.tally().tally()})
+
+	lock.set(entry)
+	err = journal.save(filepath.Join(dstBase, lockName))
+	if err != nil {
+		return err
+	}
+	err = lock.write(dstBase)
+	if err != nil {
+		return err
+	}
+
+	// Links are not kept in the history, until they are materialized
+	if !self.link {
+		paths := []string{}
+		for _, path := range journal.order {
+			if change := journal.change(path); change == "-" || change == "" || path == filepath.Join(dstBase, lockName) {
+				continue
+			}
+			paths = append(paths, path)
+		}
+		err := recordHistory(journal, dstBase, entry, paths, self.history)
+		if err != nil {
+			return err
+		}
+	}
+
+	if self.verify {
+		importPath, hostPath, hostDir := dstPath, "", ""
+		if dstName != "" {
+			hostPath, hostDir = dstPkg.ImportPath, dstPkg.Dir
+			if importPkg, err := buildImport(dstPath); err == nil {
+				importPath = importPkg.ImportPath
+			}
+		}
+		return verify(ctx, importPath, dstPath, hostPath, hostDir)
 	}
 
 	return nil
@@ -240,16 +884,46 @@ func main(dst string, src string, extra map[string]string) error {
 
 func usage() {
 	fmt.Fprintf(os.Stderr, "Usage: %s [target]\n", mainName)
+	fmt.Fprintf(os.Stderr, "       %s status [directory]\n", mainName)
+	fmt.Fprintf(os.Stderr, "       %s audit [directory]\n", mainName)
+	fmt.Fprintf(os.Stderr, "       %s history [directory]\n", mainName)
+	fmt.Fprintf(os.Stderr, "       %s rollback [n] [directory]\n", mainName)
+	fmt.Fprintf(os.Stderr, "       %s materialize [directory]\n", mainName)
 	kilt.PrintDefaults(flag)
-	fmt.Fprintf(os.Stderr, kilt.GraveTrim(`
+	if mainPkg == "" {
+		fmt.Fprintf(os.Stderr, kilt.GraveTrim(`
+
+    # Import every package named by a %simport directive in the current directory
+    $ %s
+
+    # ...or as part of "go generate"
+    //go:generate %s
+    `), directivePrefix, mainName, mainName)
+	} else {
+		fmt.Fprintf(os.Stderr, kilt.GraveTrim(`
 
     # Import %q into the current directory
     $ %s
 
     # Import %q into another directory
     $ %s ./xyzzy
-
     `), mainPkg, mainName, mainPkg, mainName)
+	}
+	fmt.Fprintf(os.Stderr, kilt.GraveTrim(`
+
+    # Report on every smuggled package (under the current directory), and what an update would bring in
+    $ %s status
+
+    # Inventory the smuggled code (under the current directory), checking it against smuggol.lock
+    $ %s audit
+
+    # Undo the last import (of the package smuggled into the current directory)
+    $ %s rollback
+
+    # Replace the symlinks of a -link import (under the current directory) with copies, before committing
+    $ %s materialize
+
+    `), mainName, mainName, mainName, mainName)
 }
 
 // Main is the entry point for a command-line application.
@@ -260,7 +934,9 @@ func usage() {
 //
 // 2. The import URL where the import package is located (e.g. "github.com/robertkrimen/terst")
 //
-// 3. A final, optional parameter (pass nil unless you know what you're doing)
+// 3. A final, optional parameter (pass nil unless you know what you're doing): the extra
+// files to generate in the host package, as a map of filename to template (see MainFS for
+// templates from a directory or an embed.FS)
 //
 // For example (terst-import):
 //
@@ -268,23 +944,93 @@ func usage() {
 //          smuggol.Main("terst-import", "github.com/robertkrimen/terst", nil)
 //      }
 //
+// If the import URL is empty, then the packages to import are taken from
+// directives in the source of the host package instead:
+//
+//	//go:generate smuggol
+//	//smuggol:import github.com/robertkrimen/terst
+//	//smuggol:import github.com/robertkrimen/dbg as debug
+//
+// If anything goes wrong, Main exits with a status that depends on the kind of error (see ExitCode)
 func Main(name, pkg string, extra map[string]string) {
+	exit(Run(name, pkg, extra, os.Args[1:]))
+}
+
+// MainFS is Main, with the extra files coming from templates (e.g. an embed.FS, or os.DirFS for a directory)
+// instead of a map[string]string: each *.tmpl file becomes a file in the host package
+//
+//      //go:embed templates
+//      var templates embed.FS
+//
+//      func main() {
+//          dir, _ := fs.Sub(templates, "templates")
+//          smuggol.MainFS("terst-import", "github.com/robertkrimen/terst", dir)
+//      }
+func MainFS(name, pkg string, templates fs.FS) {
+	exit(RunFS(name, pkg, templates, os.Args[1:]))
+}
+
+func exit(err error) {
+	if err != nil {
+		emit(_event{Action: "error", Error: err.Error()})
+		fmt.Fprintf(os.Stderr, "%s: %s\n", mainName, err)
+		os.Exit(ExitCode(err))
+	}
+}
+
+// Run is Main, with the given (command-line) arguments, except that it returns any error (see Error)
+// instead of exiting
+func Run(name, pkg string, extra map[string]string, arguments []string) error {
+	return run(name, pkg, extra, arguments)
+}
+
+// RunFS is MainFS, with the given (command-line) arguments, except that it returns any error (see Error)
+// instead of exiting
+func RunFS(name, pkg string, templates fs.FS, arguments []string) error {
+	return run(name, pkg, templates, arguments)
+}
+
+func run(name, pkg string, extra interface{}, arguments []string) error {
 	mainName = name
 	mainPkg = pkg
 
 	flag.Usage = usage
-	flag.Parse(os.Args[1:])
+	flag.Parse(arguments)
 
-	err := func() error {
-		return main(flag.Arg(0), mainPkg, extra)
+	return func() error {
+		switch flag.Arg(0) {
+		case "status":
+			return mainStatus(flag.Arg(1), os.Stdout)
+		case "audit":
+			return mainAudit(flag.Arg(1), os.Stdout)
+		case "history":
+			return mainHistory(flag.Arg(1), os.Stdout)
+		case "rollback":
+			return mainRollback(flag.Arg(1), flag.Arg(2))
+		case "materialize":
+			return mainMaterialize(flag.Arg(1))
+		}
+		run := func() error {
+			if mainPkg == "" {
+				return mainDirective(flag.Arg(0), extra)
+			}
+			src := mainPkg
+			if flag_version != "" {
+				src, _ = splitVersion(src)
+				src += "@" + flag_version
+			}
+			return main(flag.Arg(0), src, extra)
+		}
+		if flag_watch {
+			mainWatch = newWatch(flag_watchEvery)
+			return mainWatch.loop(run)
+		}
+		return run()
 	}()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s: %s\n", mainName, err)
-		os.Exit(1)
-	}
 }
 
-func fmtPipe(input func(io.Writer) error, output io.Writer) error {
+// fmtPipe pipes what input writes through gofmt (if available) into output, reporting whether it was formatted
+func fmtPipe(input func(io.Writer) error, output io.Writer) (bool, error) {
 
 	inputOutput := output
 
@@ -298,7 +1044,7 @@ func fmtPipe(input func(io.Writer) error, output io.Writer) error {
 	}
 
 	if !gofmt {
-		return input(output)
+		return false, input(output)
 	}
 
 	cmd := exec.Command("gofmt")
@@ -323,5 +1069,5 @@ func fmtPipe(input func(io.Writer) error, output io.Writer) error {
 			err = cmd.Wait()
 		}
 	}
-	return err
+	return cmd != nil, err
 }
@@ -70,7 +70,7 @@ func Test(t *testing.T) {
 	err = testMain("test/asdf", mainPkg, nil)
 	Is(err, nil)
 	exists("test/asdf/terst/terst.go",
-		"This file was AUTOMATICALLY GENERATED by asdf-import \\(smuggol\\) from github.com/robertkrimen/terst",
+		"(?m)^// Code generated by asdf-import \\(smuggol\\) from github.com/robertkrimen/terst\\. DO NOT EDIT\\.$",
 		"(?m)^package terst",
 		"(?m)^func Is\\(",
 	)
@@ -79,7 +79,7 @@ func Test(t *testing.T) {
 	err = testMain("test/asdf", mainPkg, extra)
 	Is(err, nil)
 	exists("test/asdf/terst.go",
-		"This file was AUTOMATICALLY GENERATED by asdf-import \\(smuggol\\) for github.com/robertkrimen/terst",
+		"(?m)^// Code generated by asdf-import \\(smuggol\\) for github.com/robertkrimen/terst\\. DO NOT EDIT\\.$",
 		"(?m)^package asdf",
 	)
 
@@ -87,7 +87,7 @@ func Test(t *testing.T) {
 	err = testMain("test/asdf", mainPkg, extra)
 	Is(err, nil)
 	exists("test/asdf/terst.go",
-		"This file was AUTOMATICALLY GENERATED by asdf-import \\(smuggol\\) for github.com/robertkrimen/terst",
+		"(?m)^// Code generated by asdf-import \\(smuggol\\) for github.com/robertkrimen/terst\\. DO NOT EDIT\\.$",
 		"(?m)^\\s+package asdf",
 	)
 
@@ -0,0 +1,185 @@
+package smuggol
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The policy file (smuggol.policy, in the host package or a directory above it, up to the
+// root of the module or repository) limits what may be smuggled:
+//
+//      {
+//          "allow": ["github.com/robertkrimen/", "golang.org/x/"],
+//          "deny": ["github.com/robertkrimen/xyzzy/..."],
+//          "maxSize": 262144,
+//          "imports": ["unsafe", "os/exec", "net/...", "cgo"]
+//      }
+const policyName = "smuggol.policy"
+
+type _policy struct {
+	Allow   []string `json:"allow,omitempty"`   // Import path prefixes that may be smuggled (default: anything)
+	Deny    []string `json:"deny,omitempty"`    // Packages that may not be smuggled
+	MaxSize int64    `json:"maxSize,omitempty"` // The maximum size (in bytes) of the .go files of a package
+	Imports []string `json:"imports,omitempty"` // Packages that smuggled code may not import ("cgo" for import "C")
+
+	path string
+}
+
+// _violation is a single violation of the policy, with its position (if any)
+type _violation struct {
+	position token.Position
+	message  string
+}
+
+func (self _violation) String() string {
+	if self.position.Filename == "" {
+		return self.message
+	}
+	return fmt.Sprintf("%s: %s", self.position, self.message)
+}
+
+// findPolicy reads the policy for the host package in dir: the file given by -policy or,
+// if none, the nearest smuggol.policy. It returns nil if there is no policy
+func findPolicy(dir, file string) (*_policy, error) {
+	if file == "" {
+		for {
+			candidate := filepath.Join(dir, policyName)
+			if _, err := os.Stat(candidate); err == nil {
+				file = candidate
+				break
+			}
+			_, git := os.Stat(filepath.Join(dir, ".git"))
+			_, goMod := os.Stat(filepath.Join(dir, "go.mod"))
+			parent := filepath.Dir(dir)
+			if git == nil || goMod == nil || parent == dir {
+				return nil, nil
+			}
+			dir = parent
+		}
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	self := &_policy{path: file}
+	err = json.Unmarshal(content, self)
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: file, Err: err}
+	}
+	return self, nil
+}
+
+// matchPackage reports whether importPath matches pattern: either a path.Match pattern
+// or, like the go command, a pattern ending in "/..." (for a package and everything below it)
+func matchPackage(pattern, importPath string) bool {
+	if prefix := strings.TrimSuffix(pattern, "/..."); prefix != pattern {
+		return importPath == prefix || strings.HasPrefix(importPath, prefix+"/")
+	}
+	matched, _ := path.Match(pattern, importPath)
+	return matched
+}
+
+// allowed reports whether importPath starts with one of the allowed prefixes (a prefix
+// matches whole path elements, unless it ends in "/")
+func (self *_policy) allowed(importPath string) bool {
+	if len(self.Allow) == 0 {
+		return true
+	}
+	for _, prefix := range self.Allow {
+		if importPath == prefix || strings.HasPrefix(importPath, prefix) && (strings.HasSuffix(prefix, "/") || importPath[len(prefix)] == '/') {
+			return true
+		}
+	}
+	return false
+}
+
+// check evaluates source against the policy, returning every violation
+func (self *_policy) check(source *_source) ([]_violation, error) {
+	result := []_violation{}
+	name := filepath.Base(self.path)
+
+	if !self.allowed(source.path) {
+		result = append(result, _violation{message: fmt.Sprintf("%s is not allowed (%s)", source.path, name)})
+	}
+	for _, pattern := range self.Deny {
+		if matchPackage(pattern, source.path) {
+			result = append(result, _violation{message: fmt.Sprintf("%s is denied by %q (%s)", source.path, pattern, name)})
+			break
+		}
+	}
+
+	pkg := source.pkg
+	files := append(append([]string{}, pkg.GoFiles...), pkg.CgoFiles...)
+	size := int64(0)
+	fileSet := token.NewFileSet()
+	for _, file := range files {
+		filename := filepath.Join(pkg.Dir, file)
+		info, err := os.Stat(filename)
+		if err != nil {
+			return nil, err
+		}
+		size += info.Size()
+
+		if len(self.Imports) == 0 {
+			continue
+		}
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		// Report positions relative to the import path, rather than wherever the source was extracted to
+		parsed, err := parser.ParseFile(fileSet, path.Join(source.path, file), content, parser.ImportsOnly)
+		if err != nil {
+			return nil, err
+		}
+		for _, spec := range parsed.Imports {
+			importPath, _ := strconv.Unquote(spec.Path.Value)
+			for _, pattern := range self.Imports {
+				if pattern == "cgo" && importPath == "C" || pattern != "cgo" && matchPackage(pattern, importPath) {
+					result = append(result, _violation{
+						position: fileSet.Position(spec.Pos()),
+						message:  fmt.Sprintf("import %q is prohibited (%s)", importPath, name),
+					})
+					break
+				}
+			}
+		}
+	}
+	if self.MaxSize > 0 && size > self.MaxSize {
+		result = append(result, _violation{message: fmt.Sprintf("%s is %d bytes, more than the maximum of %d (%s)", source.path, size, self.MaxSize, name)})
+	}
+	return result, nil
+}
+
+// checkPolicy evaluates source against the policy for the host package in dir (if there is one),
+// reporting every violation. It fails if there are any
+func checkPolicy(ctx context.Context, dir, file string, source *_source) error {
+	policy, err := findPolicy(dir, file)
+	if err != nil || policy == nil {
+		return err
+	}
+	violations, err := policy.check(source)
+	if err != nil {
+		return err
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	if !flag_quiet {
+		for _, violation := range violations {
+			fmt.Fprintf(outputOf(ctx).err(), "%s\n", violation)
+		}
+	}
+	if len(violations) == 1 {
+		return fail(ErrPolicy, fmt.Errorf("policy: 1 violation"))
+	}
+	return fail(ErrPolicy, fmt.Errorf("policy: %d violations", len(violations)))
+}
@@ -0,0 +1,81 @@
+package smuggol
+
+import (
+	"context"
+	. "github.com/robertkrimen/smuggol/terst"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	Terst(t)
+
+	Is(matchPackage("net/...", "net"), true)
+	Is(matchPackage("net/...", "net/http"), true)
+	Is(matchPackage("net/...", "network"), false)
+	Is(matchPackage("os/exec", "os/exec"), true)
+	Is(matchPackage("os/exec", "os"), false)
+	Is(matchPackage("github.com/*/xyzzy", "github.com/robertkrimen/xyzzy"), true)
+
+	policy := &_policy{Allow: []string{"github.com/robertkrimen", "golang.org/x/"}}
+	Is(policy.allowed("github.com/robertkrimen"), true)
+	Is(policy.allowed("github.com/robertkrimen/terst"), true)
+	Is(policy.allowed("github.com/robertkrimenx/terst"), false)
+	Is(policy.allowed("golang.org/x/text"), true)
+	Is(policy.allowed("example.com/xyzzy"), false)
+	Is((&_policy{}).allowed("example.com/xyzzy"), true)
+
+	base, err := ioutil.TempDir("", "smuggol.")
+	Is(err, nil)
+	if err != nil {
+		FailNow()
+	}
+	defer os.RemoveAll(base)
+
+	write := func(name, content string) {
+		path := filepath.Join(base, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0777)
+		ioutil.WriteFile(path, []byte(content), 0666)
+	}
+	write("go.mod", "module example.com/host\n")
+	write(policyName, `{"allow": ["example.com/"], "deny": ["example.com/xyzzy/..."], "maxSize": 32, "imports": ["unsafe", "net/..."]}`)
+	write("host/host.go", "package host\n")
+	write("xyzzy/xyzzy.go", "package xyzzy\n\nimport (\n\t\"fmt\"\n\t\"net/http\"\n\t\"unsafe\"\n)\n")
+
+	policy, err = findPolicy(filepath.Join(base, "host"), "")
+	Is(err, nil)
+	if policy == nil {
+		FailNow()
+	}
+	Is(policy.path, filepath.Join(base, policyName))
+	Is(policy.MaxSize, 32)
+
+	pkg, err := build.ImportDir(filepath.Join(base, "xyzzy"), 0)
+	Is(err, nil)
+	violations, err := policy.check(&_source{path: "example.com/xyzzy", pkg: pkg})
+	Is(err, nil)
+	Is(len(violations), 4)
+	if len(violations) == 4 {
+		Is(violations[0].String(), `example.com/xyzzy is denied by "example.com/xyzzy/..." (smuggol.policy)`)
+		Is(violations[1].String(), `example.com/xyzzy/xyzzy.go:5:2: import "net/http" is prohibited (smuggol.policy)`)
+		Is(violations[2].String(), `example.com/xyzzy/xyzzy.go:6:2: import "unsafe" is prohibited (smuggol.policy)`)
+		Is(violations[3].String(), `example.com/xyzzy is 55 bytes, more than the maximum of 32 (smuggol.policy)`)
+	}
+
+	flag_quiet = true
+	err = checkPolicy(context.Background(), filepath.Join(base, "host"), "", &_source{path: "example.com/xyzzy", pkg: pkg})
+	flag_quiet = false
+	Like(err, `^policy: 4 violations$`)
+
+	// The search stops at the root of the module
+	write("nested/go.mod", "module example.com/nested\n")
+	policy, err = findPolicy(filepath.Join(base, "nested"), "")
+	Is(err, nil)
+	Is(policy == nil, true)
+
+	_, err = findPolicy(base, filepath.Join(base, "nothing.policy"))
+	IsNot(err, nil)
+}
@@ -0,0 +1,495 @@
+package smuggol
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// _proxy is a module proxy, speaking the GOPROXY protocol:
+//
+//      <base>/<module>/@v/list
+//      <base>/<module>/@v/<version>.info
+//      <base>/<module>/@v/<version>.mod
+//      <base>/<module>/@v/<version>.zip
+//
+// The base is an http:// or https:// URL, or a file:// URL for a proxy on disk
+// (e.g. a copy of $GOPATH/pkg/mod/cache/download)
+type _proxy struct {
+	base   string
+	client *http.Client
+	ctx    context.Context // For cancelling a request
+}
+
+type _proxyInfo struct {
+	Version string
+	Time    string
+}
+
+// errNotFound is returned by the proxy for a missing module or version
+var errNotFound = errors.New("not found")
+
+func newProxy(ctx context.Context, base string) *_proxy {
+	return &_proxy{
+		base:   strings.TrimSuffix(base, "/"),
+		client: http.DefaultClient,
+		ctx:    ctx,
+	}
+}
+
+// fetch gets <base>/<module>/<suffix>, where suffix is e.g. "@v/list" or "@latest"
+func (self *_proxy) fetch(module, suffix string) ([]byte, error) {
+	escaped, err := escapeModulePath(module)
+	if err != nil {
+		return nil, err
+	}
+	target := self.base + "/" + escaped + "/" + suffix
+
+	if strings.HasPrefix(self.base, "file://") {
+		location, err := url.Parse(target)
+		if err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadFile(filepath.FromSlash(location.Path))
+		if os.IsNotExist(err) {
+			return nil, errNotFound
+		}
+		return content, err
+	}
+
+	request, err := http.NewRequestWithContext(self.ctx, "GET", target, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := self.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusGone:
+		return nil, errNotFound
+	default:
+		return nil, fmt.Errorf("%s: %s", target, response.Status)
+	}
+	return ioutil.ReadAll(response.Body)
+}
+
+// fetchVersion gets <base>/<module>/@v/<version><extension>, where extension is e.g. ".info" or ".zip"
+func (self *_proxy) fetchVersion(module, version, extension string) ([]byte, error) {
+	escaped, err := escapeVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	return self.fetch(module, "@v/"+escaped+extension)
+}
+
+// list returns the (known, tagged) versions of module, sorted from lowest to highest
+func (self *_proxy) list(module string) ([]string, error) {
+	content, err := self.fetch(module, "@v/list")
+	if err != nil {
+		return nil, err
+	}
+	result := []string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 && validVersion(fields[0]) {
+			result = append(result, fields[0])
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return compareVersion(result[i], result[j]) < 0
+	})
+	return result, nil
+}
+
+func (self *_proxy) info(module, version string) (*_proxyInfo, error) {
+	content, err := self.fetchVersion(module, version, ".info")
+	if err != nil {
+		return nil, err
+	}
+	info := &_proxyInfo{}
+	err = json.Unmarshal(content, info)
+	if err != nil {
+		return nil, fmt.Errorf("%s@%s: invalid .info: %s", module, version, err)
+	}
+	return info, nil
+}
+
+// latest returns the highest release version of module (or the highest pre-release, if
+// there are no releases), falling back to what the proxy says is @latest
+func (self *_proxy) latest(module string) (string, error) {
+	versions, err := self.list(module)
+	if err != nil {
+		return "", err
+	}
+	for index := len(versions) - 1; index >= 0; index-- {
+		if !strings.Contains(versions[index], "-") {
+			return versions[index], nil
+		}
+	}
+	if len(versions) > 0 {
+		return versions[len(versions)-1], nil
+	}
+	content, err := self.fetch(module, "@latest")
+	if err != nil {
+		return "", err
+	}
+	info := &_proxyInfo{}
+	err = json.Unmarshal(content, info)
+	if err != nil || info.Version == "" {
+		return "", fmt.Errorf("%s: no versions", module)
+	}
+	return info.Version, nil
+}
+
+// module finds the module providing the package at importPath, trying the longest
+// candidate module path first (like the go command does)
+func (self *_proxy) module(importPath, version string) (module, resolved string, err error) {
+	for candidate := importPath; candidate != "." && candidate != ""; candidate = path.Dir(candidate) {
+		if version == "" || version == "latest" {
+			resolved, err = self.latest(candidate)
+		} else {
+			var info *_proxyInfo
+			info, err = self.info(candidate, version)
+			if err == nil {
+				resolved = info.Version
+			}
+		}
+		if err == errNotFound {
+			continue
+		}
+		if err != nil {
+			return "", "", fail(ErrFetch, err)
+		}
+		return candidate, resolved, nil
+	}
+	if version == "" {
+		return "", "", fail(ErrSourceNotFound, fmt.Errorf("%s: no module found (via %s)", importPath, self.base))
+	}
+	return "", "", fail(ErrSourceNotFound, fmt.Errorf("%s@%s: no module found (via %s)", importPath, version, self.base))
+}
+
+// resolveProxy downloads (and hashes) the module providing importPath from the proxy
+// at base, then extracts just the package itself into a temporary directory
+func resolveProxy(ctx context.Context, base, importPath, version string) (*_source, error) {
+	proxy := newProxy(ctx, base)
+	module, resolved, err := proxy.module(importPath, version)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := proxy.fetchVersion(module, resolved, ".zip")
+	if err != nil {
+		return nil, fail(ErrFetch, fmt.Errorf("%s@%s: .zip: %s", module, resolved, err))
+	}
+	sum, err := hashZip(archive)
+	if err != nil {
+		return nil, fail(ErrFetch, fmt.Errorf("%s@%s: %s", module, resolved, err))
+	}
+	outputOf(ctx).emit(_event{Action: "fetch", Package: module, Version: resolved, Sum: sum})
+
+	tmp, err := ioutil.TempDir("", "smuggol.")
+	if err != nil {
+		return nil, err
+	}
+	source := &_source{
+		path:     importPath,
+		version:  resolved,
+		revision: resolved,
+		module:   module,
+		sum:      sum,
+		tmp:      tmp,
+	}
+
+	prefix := module + "@" + resolved + "/"
+	if importPath != module {
+		prefix += strings.TrimPrefix(importPath, module+"/") + "/"
+	}
+	err = extractZip(archive, prefix, tmp)
+	if err != nil {
+		source.cleanup()
+		return nil, fail(ErrFetch, fmt.Errorf("%s: %s", source, err))
+	}
+	source.pkg, err = build.Default.ImportDir(tmp, 0)
+	if err != nil {
+		source.cleanup()
+		return nil, fail(ErrSourceNotFound, fmt.Errorf("%s: %s", source, err))
+	}
+	return source, nil
+}
+
+// extractZip writes the files directly under prefix (but not in any subdirectory) into dst, along
+// with the nearest license files (see _licenseFiles)
+func extractZip(archive []byte, prefix, dst string) error {
+	reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return err
+	}
+	licenses := newLicenseFiles(strings.TrimSuffix(prefix, "/"))
+	for _, file := range reader.File {
+		if licenses.want(file.Name) && !file.FileInfo().IsDir() {
+			input, err := file.Open()
+			if err != nil {
+				return err
+			}
+			content, err := ioutil.ReadAll(input)
+			input.Close()
+			if err != nil {
+				return err
+			}
+			licenses.add(file.Name, content)
+			continue
+		}
+		if !strings.HasPrefix(file.Name, prefix) {
+			continue
+		}
+		name := file.Name[len(prefix):]
+		if name == "" || strings.Contains(name, "/") || file.FileInfo().IsDir() {
+			continue
+		}
+		input, err := file.Open()
+		if err != nil {
+			return err
+		}
+		content, err := ioutil.ReadAll(input)
+		input.Close()
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(filepath.Join(dst, name), content, 0666)
+		if err != nil {
+			return err
+		}
+	}
+	return licenses.write(dst)
+}
+
+// hashZip computes the "h1:" hash of a module zip, the same as go.sum (see
+// golang.org/x/mod/sumdb/dirhash): a SHA-256 over a sorted summary of SHA-256 file hashes
+func hashZip(archive []byte) (string, error) {
+	reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return "", err
+	}
+	files := append([]*zip.File{}, reader.File...)
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+	summary := sha256.New()
+	for _, file := range files {
+		if strings.Contains(file.Name, "\n") {
+			return "", fmt.Errorf("invalid file name in zip: %q", file.Name)
+		}
+		input, err := file.Open()
+		if err != nil {
+			return "", err
+		}
+		hash := sha256.New()
+		_, err = io.Copy(hash, input)
+		input.Close()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(summary, "%x  %s\n", hash.Sum(nil), file.Name)
+	}
+	return "h1:" + base64.StdEncoding.EncodeToString(summary.Sum(nil)), nil
+}
+
+// verifySum checks the hash of a source downloaded from a proxy against what was
+// recorded before: in the lockfile, and in the go.sum of the host module
+func verifySum(source *_source, lock *_lock, dir string) error {
+	expect := func(want, from string) error {
+		if want != "" && want != source.sum {
+			return fail(ErrDrift, fmt.Errorf("%s@%s: checksum mismatch\n\tdownloaded: %s\n\t%s: %s", source.module, source.version, source.sum, from, want))
+		}
+		return nil
+	}
+	for _, entry := range lock.Imports {
+		if entry.Module == source.module && entry.Version == source.version {
+			err := expect(entry.Sum, lockName)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return expect(goSum(dir, source.module, source.version), "go.sum")
+}
+
+// goSum looks for the hash of module@version in the go.sum of the module containing dir
+func goSum(dir, module, version string) string {
+	for dir != "" {
+		file, err := os.Open(filepath.Join(dir, "go.sum"))
+		if err == nil {
+			defer file.Close()
+			scanner := bufio.NewScanner(file)
+			for scanner.Scan() {
+				fields := strings.Fields(scanner.Text())
+				if len(fields) == 3 && fields[0] == module && fields[1] == version {
+					return fields[2]
+				}
+			}
+			return ""
+		}
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return ""
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return ""
+}
+
+// escapeModulePath escapes a module path for a proxy URL: each upper-case letter
+// becomes "!" followed by the lower-case letter
+func escapeModulePath(module string) (string, error) {
+	result, ok := escapeUpper(module)
+	if !ok {
+		return "", fmt.Errorf("invalid module path %q", module)
+	}
+	return result, nil
+}
+
+// escapeVersion escapes a version for a proxy URL, the same way as a module path
+// (see escapeModulePath), e.g. v1.0.0-RC.1 => v1.0.0-!r!c.1
+func escapeVersion(version string) (string, error) {
+	result, ok := escapeUpper(version)
+	if !ok {
+		return "", fmt.Errorf("invalid version %q", version)
+	}
+	return result, nil
+}
+
+func escapeUpper(value string) (string, bool) {
+	var buffer bytes.Buffer
+	for _, chr := range value {
+		if chr == '!' || chr >= unicode.MaxASCII {
+			return "", false
+		}
+		if unicode.IsUpper(chr) {
+			buffer.WriteByte('!')
+			chr = unicode.ToLower(chr)
+		}
+		buffer.WriteRune(chr)
+	}
+	return buffer.String(), true
+}
+
+// validVersion reports whether version looks like a semantic version: v1.2.3[-pre][+build]
+func validVersion(version string) bool {
+	_, ok := parseVersion(version)
+	return ok
+}
+
+type _version struct {
+	number     [3]int
+	prerelease string
+}
+
+func parseVersion(version string) (result _version, ok bool) {
+	if !strings.HasPrefix(version, "v") {
+		return
+	}
+	version = version[1:]
+	if index := strings.Index(version, "+"); index >= 0 {
+		version = version[:index]
+	}
+	if index := strings.Index(version, "-"); index >= 0 {
+		result.prerelease = version[index+1:]
+		version = version[:index]
+	}
+	parts := strings.Split(version, ".")
+	if len(parts) != 3 {
+		return
+	}
+	for index, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 {
+			return
+		}
+		result.number[index] = number
+	}
+	return result, true
+}
+
+// compareVersion compares two semantic versions, returning -1, 0, or +1 (an invalid
+// version is lower than any valid version)
+func compareVersion(a, b string) int {
+	x, xOk := parseVersion(a)
+	y, yOk := parseVersion(b)
+	switch {
+	case !xOk && !yOk:
+		return strings.Compare(a, b)
+	case !xOk:
+		return -1
+	case !yOk:
+		return 1
+	}
+	for index := range x.number {
+		if x.number[index] != y.number[index] {
+			if x.number[index] < y.number[index] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case x.prerelease == y.prerelease:
+		return 0
+	case x.prerelease == "":
+		return 1
+	case y.prerelease == "":
+		return -1
+	}
+	return comparePrerelease(x.prerelease, y.prerelease)
+}
+
+func comparePrerelease(x, y string) int {
+	a, b := strings.Split(x, "."), strings.Split(y, ".")
+	for index := 0; index < len(a) && index < len(b); index++ {
+		if a[index] == b[index] {
+			continue
+		}
+		m, mErr := strconv.Atoi(a[index])
+		n, nErr := strconv.Atoi(b[index])
+		switch {
+		case mErr == nil && nErr == nil:
+			if m < n {
+				return -1
+			}
+			return 1
+		case mErr == nil:
+			return -1
+		case nErr == nil:
+			return 1
+		}
+		return strings.Compare(a[index], b[index])
+	}
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,164 @@
+package smuggol
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	. "github.com/robertkrimen/smuggol/terst"
+	"go/build"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProxy(t *testing.T) {
+	Terst(t)
+
+	archive := func(version string) []byte {
+		var buffer bytes.Buffer
+		writer := zip.NewWriter(&buffer)
+		for name, content := range map[string]string{
+			"go.mod":              "module example.com/xyzzy\n",
+			"xyzzy.go":            "package xyzzy\n",
+			"nothing/nothing.go":  "package nothing\n\nconst Version = \"" + version + "\"\n",
+			"nothing/deeper/x.go": "package deeper\n",
+		} {
+			file, _ := writer.Create("example.com/xyzzy@" + version + "/" + name)
+			file.Write([]byte(content))
+		}
+		writer.Close()
+		return buffer.Bytes()
+	}
+
+	files := map[string][]byte{
+		"example.com/xyzzy/@v/list":               []byte("v1.0.0\nv1.1.0\nv1.2.0-rc.1\n"),
+		"example.com/xyzzy/@v/v1.0.0.info":        []byte(`{"Version":"v1.0.0"}`),
+		"example.com/xyzzy/@v/v1.0.0.zip":         archive("v1.0.0"),
+		"example.com/xyzzy/@v/v1.1.0.info":        []byte(`{"Version":"v1.1.0"}`),
+		"example.com/xyzzy/@v/v1.1.0.zip":         archive("v1.1.0"),
+		"example.com/xyzzy/@v/v1.2.0-rc.1.info":   []byte(`{"Version":"v1.2.0-rc.1"}`),
+		"example.com/xyzzy/@v/v1.0.1-!r!c.1.info": []byte(`{"Version":"v1.0.1-RC.1"}`),
+		"example.com/xyzzy/@v/v1.0.1-!r!c.1.zip":  archive("v1.0.1-RC.1"),
+		"example.com/xyzzy/@v/v1.0.2.info":        []byte(`{"Version":"v1.0.2"}`),
+		"example.com/xyzzy/@v/v1.0.2.zip":         []byte("xyzzy"),
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		content, exists := files[strings.TrimPrefix(request.URL.Path, "/")]
+		if !exists {
+			http.NotFound(response, request)
+			return
+		}
+		response.Write(content)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "smuggol.")
+	Is(err, nil)
+	if err != nil {
+		FailNow()
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0777)
+		ioutil.WriteFile(path, content, 0666)
+	}
+
+	for _, base := range []string{server.URL, "file://" + filepath.ToSlash(dir)} {
+		source, err := resolveProxy(context.Background(), base, "example.com/xyzzy/nothing", "")
+		Is(err, nil, base)
+		if err != nil {
+			continue
+		}
+		Is(source.module, "example.com/xyzzy")
+		Is(source.version, "v1.1.0")
+		Is(source.String(), "example.com/xyzzy/nothing@v1.1.0")
+		Is(source.pkg.Name, "nothing")
+		Is(strings.Join(source.pkg.GoFiles, ","), "nothing.go")
+		content, _ := ioutil.ReadFile(filepath.Join(source.pkg.Dir, "nothing.go"))
+		Like(string(content), `"v1.1.0"`)
+		sum, _ := hashZip(files["example.com/xyzzy/@v/v1.1.0.zip"])
+		Is(source.sum, sum)
+		source.cleanup()
+
+		source, err = resolveProxy(context.Background(), base, "example.com/xyzzy/nothing", "v1.0.0")
+		Is(err, nil, base)
+		if err == nil {
+			Is(source.version, "v1.0.0")
+			lock := &_lock{Imports: []_lockImport{{Module: "example.com/xyzzy", Version: "v1.0.0", Sum: "h1:xyzzy="}}}
+			err := verifySum(source, lock, dir)
+			Like(err, `checksum mismatch`)
+			Is(errors.Is(err, ErrDrift), true)
+			lock.Imports[0].Sum = source.sum
+			Is(verifySum(source, lock, dir), nil)
+			source.cleanup()
+		}
+
+		_, err = resolveProxy(context.Background(), base, "example.com/xyzzy/nothing", "v9.9.9")
+		Like(err, `no module found`)
+		Is(errors.Is(err, ErrSourceNotFound), true)
+
+		// The version is escaped (in the URL) as well
+		source, err = resolveProxy(context.Background(), base, "example.com/xyzzy/nothing", "v1.0.1-RC.1")
+		Is(err, nil, base)
+		if err == nil {
+			Is(source.version, "v1.0.1-RC.1")
+			source.cleanup()
+		}
+
+		// There is a v1.2.0-rc.1, but no .zip
+		_, err = resolveProxy(context.Background(), base, "example.com/xyzzy/nothing", "v1.2.0-rc.1")
+		Like(err, `\.zip: `)
+		Is(ExitCode(err), 5)
+
+		// There is a v1.0.2, but its .zip is not a zip
+		_, err = resolveProxy(context.Background(), base, "example.com/xyzzy/nothing", "v1.0.2")
+		Like(err, `v1\.0\.2: `)
+		Is(errors.Is(err, ErrFetch), true)
+	}
+
+	Is(compareVersion("v1.10.0", "v1.9.0"), 1)
+	Is(compareVersion("v1.0.0-rc.1", "v1.0.0"), -1)
+	Is(compareVersion("v1.0.0-rc.2", "v1.0.0-rc.10"), -1)
+	Is(compareVersion("v1.0.0", "xyzzy"), 1)
+
+	escaped, _ := escapeModulePath("github.com/Azure/azure-sdk")
+	Is(escaped, "github.com/!azure/azure-sdk")
+	escaped, _ = escapeVersion("v1.0.0-RC.1")
+	Is(escaped, "v1.0.0-!r!c.1")
+	_, err = escapeVersion("v1.0.0-!rc")
+	Like(err, `invalid version`)
+}
+
+func TestHashZip(t *testing.T) {
+	Terst(t)
+
+	// A real module (from the module cache), hashed as go does (see its .ziphash)
+	caches := []string{os.Getenv("GOMODCACHE")}
+	for _, gopath := range filepath.SplitList(build.Default.GOPATH) {
+		caches = append(caches, filepath.Join(gopath, "pkg", "mod"))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		caches = append(caches, filepath.Join(home, "go", "pkg", "mod"))
+	}
+	for _, cache := range caches {
+		if cache == "" {
+			continue
+		}
+		archive, err := ioutil.ReadFile(filepath.Join(cache, "cache", "download", "bou.ke", "monkey", "@v", "v1.0.1.zip"))
+		if err != nil {
+			continue
+		}
+		sum, err := hashZip(archive)
+		Is(err, nil)
+		Is(sum, "h1:zEMLInw9xvNakzUUPjfS4Ds6jYPqCFx3m7bRmG5NH2U=")
+		return
+	}
+	t.Skip("bou.ke/monkey@v1.0.1 is not in the module cache")
+}
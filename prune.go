@@ -0,0 +1,391 @@
+package smuggol
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Pruning (tree-shaking) keeps only the part of an import package that the host
+// package actually uses: starting from every identifier of the import package that
+// the host refers to (the roots), plus any init() and blank (var _ = ...) declarations,
+// follow the references between the top-level declarations of the import package.
+// Whatever is not reached is dropped, along with any import that is no longer used.
+//
+// To be safe, every method of a reachable type is kept (it might be needed to satisfy
+// an interface), as is every constant in a const (...) group that has a reachable
+// constant (iota). A variable that is not reached is dropped even if its initializer
+// has side effects.
+
+// pruneKey is how a top-level object is identified: "Name", or "Type.Method" for a method
+func pruneKey(object types.Object) string {
+	if function, ok := object.(*types.Func); ok {
+		if signature, ok := function.Type().(*types.Signature); ok && signature.Recv() != nil {
+			recv := signature.Recv().Type()
+			if pointer, ok := recv.(*types.Pointer); ok {
+				recv = pointer.Elem()
+			}
+			if named, ok := recv.(*types.Named); ok {
+				return named.Obj().Name() + "." + function.Name()
+			}
+			return ""
+		}
+	}
+	if object.Pkg() != nil && object.Parent() == object.Pkg().Scope() {
+		return object.Name()
+	}
+	return ""
+}
+
+// pruneRoots returns the keys of everything in the package at importPath that the
+// (type-checked) host package refers to
+func pruneRoots(host *_checked, importPath string) map[string]bool {
+	result := map[string]bool{}
+	for _, object := range host.info.Uses {
+		if object.Pkg() == nil || object.Pkg().Path() != importPath {
+			continue
+		}
+		if key := pruneKey(object); key != "" {
+			result[key] = true
+		}
+	}
+	return result
+}
+
+// _pruneDecl is a top-level declaration: a func, or a single spec of a type/var/const decl
+type _pruneDecl struct {
+	node  ast.Node
+	keys  []string
+	uses  map[string]bool
+	root  bool
+	group *ast.GenDecl // For a spec
+}
+
+// _pruneResult summarizes what pruning did
+type _pruneResult struct {
+	decls, kept   int
+	before, after int // Size in bytes
+	files         map[string][]byte
+	removed       []string // Files with nothing left in them
+}
+
+func (self _pruneResult) String() string {
+	percent := 0
+	if self.before > 0 {
+		percent = 100 * (self.before - self.after) / self.before
+	}
+	return fmt.Sprintf("kept %d of %d declarations, %d => %d bytes (-%d%%)", self.kept, self.decls, self.before, self.after, percent)
+}
+
+// prune computes the pruned content of the (type-checked) import package, given roots.
+// Nothing is written
+func prune(checked *_checked, names []string, roots map[string]bool) (*_pruneResult, error) {
+	if checked.pkg == nil {
+		return nil, fmt.Errorf("prune: unable to type-check")
+	}
+	pkg := checked.pkg
+
+	decls := []*_pruneDecl{}
+	methods := map[string][]*_pruneDecl{} // Type => its methods
+
+	usesOf := func(node ast.Node) map[string]bool {
+		result := map[string]bool{}
+		ast.Inspect(node, func(node ast.Node) bool {
+			if ident, ok := node.(*ast.Ident); ok {
+				if object := checked.info.Uses[ident]; object != nil && object.Pkg() == pkg {
+					if key := pruneKey(object); key != "" {
+						result[key] = true
+					}
+				}
+			}
+			return true
+		})
+		return result
+	}
+
+	for _, file := range checked.files {
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				object := checked.info.Defs[decl.Name]
+				if object == nil {
+					continue
+				}
+				key := pruneKey(object)
+				item := &_pruneDecl{
+					node: decl,
+					keys: []string{key},
+					uses: usesOf(decl),
+					root: key == "" || decl.Recv == nil && decl.Name.Name == "init",
+				}
+				decls = append(decls, item)
+				if decl.Recv != nil && key != "" {
+					typeName := key[:len(key)-len(decl.Name.Name)-1]
+					methods[typeName] = append(methods[typeName], item)
+				}
+			case *ast.GenDecl:
+				if decl.Tok == token.IMPORT {
+					continue
+				}
+				for _, spec := range decl.Specs {
+					item := &_pruneDecl{
+						node:  spec,
+						uses:  usesOf(spec),
+						group: decl,
+					}
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						item.keys = append(item.keys, spec.Name.Name)
+					case *ast.ValueSpec:
+						for _, name := range spec.Names {
+							if name.Name == "_" {
+								item.root = true
+							}
+							item.keys = append(item.keys, name.Name)
+						}
+					}
+					decls = append(decls, item)
+				}
+			}
+		}
+	}
+
+	byKey := map[string][]*_pruneDecl{}
+	for _, decl := range decls {
+		for _, key := range decl.keys {
+			byKey[key] = append(byKey[key], decl)
+		}
+	}
+
+	// Mark
+	reached := map[*_pruneDecl]bool{}
+	queue := []*_pruneDecl{}
+	reach := func(decl *_pruneDecl) {
+		if !reached[decl] {
+			reached[decl] = true
+			queue = append(queue, decl)
+		}
+	}
+	for key := range roots {
+		for _, decl := range byKey[key] {
+			reach(decl)
+		}
+	}
+	for _, decl := range decls {
+		if decl.root {
+			reach(decl)
+		}
+	}
+	for len(queue) > 0 {
+		decl := queue[0]
+		queue = queue[1:]
+		for key := range decl.uses {
+			for _, decl := range byKey[key] {
+				reach(decl)
+			}
+		}
+		if _, ok := decl.node.(*ast.TypeSpec); ok {
+			for _, method := range methods[decl.keys[0]] {
+				reach(method)
+			}
+		}
+		if decl.group != nil && decl.group.Tok == token.CONST {
+			for _, spec := range decl.group.Specs {
+				for _, other := range decls {
+					if other.node == spec {
+						reach(other)
+					}
+				}
+			}
+		}
+	}
+
+	// Sweep
+	result := &_pruneResult{
+		decls: len(decls),
+		kept:  len(reached),
+		files: map[string][]byte{},
+	}
+	keep := map[ast.Node]bool{}
+	for decl := range reached {
+		keep[decl.node] = true
+	}
+
+	for index, file := range checked.files {
+		name := names[index]
+		before := checked.fileSet.File(file.Pos()).Size()
+		result.before += before
+
+		removed := [][2]token.Pos{}
+		drop := func(node ast.Node, doc *ast.CommentGroup) {
+			start := node.Pos()
+			if doc != nil {
+				start = doc.Pos()
+			}
+			removed = append(removed, [2]token.Pos{start, node.End()})
+		}
+
+		decls := []ast.Decl{}
+		empty := true
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if !keep[decl] {
+					drop(decl, decl.Doc)
+					continue
+				}
+			case *ast.GenDecl:
+				if decl.Tok != token.IMPORT {
+					specs := []ast.Spec{}
+					for _, spec := range decl.Specs {
+						if keep[spec] {
+							specs = append(specs, spec)
+							continue
+						}
+						switch spec := spec.(type) {
+						case *ast.TypeSpec:
+							drop(spec, spec.Doc)
+						case *ast.ValueSpec:
+							drop(spec, spec.Doc)
+						}
+					}
+					if len(specs) == 0 {
+						drop(decl, decl.Doc)
+						continue
+					}
+					decl.Specs = specs
+				}
+			}
+			if gen, ok := decl.(*ast.GenDecl); !ok || gen.Tok != token.IMPORT {
+				empty = false
+			}
+			decls = append(decls, decl)
+		}
+		file.Decls = decls
+
+		// Drop any import that is no longer used
+		used := map[string]bool{}
+		for _, decl := range file.Decls {
+			ast.Inspect(decl, func(node ast.Node) bool {
+				if ident, ok := node.(*ast.Ident); ok {
+					if object, ok := checked.info.Uses[ident].(*types.PkgName); ok {
+						used[object.Imported().Path()] = true
+					}
+				}
+				return true
+			})
+		}
+		imports := []*ast.ImportSpec{}
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.IMPORT {
+				continue
+			}
+			specs := []ast.Spec{}
+			for _, spec := range gen.Specs {
+				spec := spec.(*ast.ImportSpec)
+				path, _ := strconv.Unquote(spec.Path.Value)
+				if used[path] || (spec.Name != nil && (spec.Name.Name == "_" || spec.Name.Name == ".")) {
+					specs = append(specs, spec)
+					imports = append(imports, spec)
+					continue
+				}
+				drop(spec, spec.Doc)
+			}
+			if len(specs) == 0 {
+				drop(gen, gen.Doc)
+			}
+			gen.Specs = specs
+		}
+		file.Imports = imports
+		decls = file.Decls[:0]
+		for _, decl := range file.Decls {
+			if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT && len(gen.Specs) == 0 {
+				continue
+			}
+			decls = append(decls, decl)
+		}
+		file.Decls = decls
+
+		// Drop any comment inside what was dropped
+		comments := []*ast.CommentGroup{}
+		for _, group := range file.Comments {
+			inside := false
+			for _, span := range removed {
+				if group.Pos() >= span[0] && group.End() <= span[1] {
+					inside = true
+					break
+				}
+			}
+			if !inside {
+				comments = append(comments, group)
+			}
+		}
+		file.Comments = comments
+
+		if empty && len(checked.files) > len(result.removed)+1 {
+			result.removed = append(result.removed, name)
+			continue
+		}
+
+		var buffer bytes.Buffer
+		err := format.Node(&buffer, checked.fileSet, file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		result.files[name] = buffer.Bytes()
+		result.after += buffer.Len()
+	}
+
+	return result, nil
+}
+
+// pruneImport prunes the import package in importDir down to what the host package
+// (in hostDir) uses, rewriting the files in place
+func pruneImport(journal *_journal, importPath, importDir, hostPath, hostDir string) (*_pruneResult, error) {
+	hostPkg, err := buildImport(hostDir)
+	if err != nil {
+		return nil, err
+	}
+	host, err := typeCheck(hostPath, hostDir, append(append([]string{}, hostPkg.GoFiles...), hostPkg.TestGoFiles...))
+	if err != nil {
+		return nil, err
+	}
+	roots := pruneRoots(host, importPath)
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("prune: nothing in %s is used by %s", importPath, hostPath)
+	}
+
+	importPkg, err := buildImport(importDir)
+	if err != nil {
+		return nil, err
+	}
+	checked, err := typeCheck(importPath, importDir, importPkg.GoFiles)
+	if err != nil {
+		return nil, err
+	}
+	result, err := prune(checked, importPkg.GoFiles, roots)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range result.removed {
+		err := journal.remove(filepath.Join(importDir, name))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	for name, content := range result.files {
+		err := journal.writeFile(filepath.Join(importDir, name), content)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,109 @@
+package smuggol
+
+import (
+	. "github.com/robertkrimen/smuggol/terst"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPrune(t *testing.T) {
+	Terst(t)
+
+	dir, err := ioutil.TempDir("", "smuggol.")
+	Is(err, nil)
+	if err != nil {
+		FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "xyzzy.go"), []byte(kiltGraveTrim(`
+package xyzzy
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	Zero = iota
+	One
+)
+
+// Used is used by the host
+func Used() *Thing {
+	return &Thing{name: helper()}
+}
+
+func helper() string {
+	return strings.ToUpper("xyzzy")
+}
+
+type Thing struct {
+	name string
+}
+
+func (self *Thing) String() string {
+	return self.name
+}
+
+// Unused is not used by anything
+func Unused() {
+	fmt.Println(Unreachable{})
+}
+
+type Unreachable struct{}
+
+func (Unreachable) Method() {}
+
+var _ = One
+    `)), 0666)
+	ioutil.WriteFile(filepath.Join(dir, "unused.go"), []byte("package xyzzy\n\nimport \"os\"\n\nfunc Exit() { os.Exit(1) }\n"), 0666)
+
+	files := []string{"unused.go", "xyzzy.go"}
+	checked, err := typeCheck("example.com/xyzzy", dir, files)
+	Is(err, nil)
+	Is(len(checked.errors), 0)
+
+	result, err := prune(checked, files, map[string]bool{"Used": true})
+	Is(err, nil)
+	if err != nil {
+		FailNow()
+	}
+
+	Is(result.removed, []string{"unused.go"})
+	content := string(result.files["xyzzy.go"])
+	Like(content, `func Used\(\) \*Thing`)
+	Like(content, `func helper\(\)`)
+	Like(content, `func \(self \*Thing\) String\(\)`)
+	Like(content, `Zero = iota`)
+	Like(content, `"strings"`)
+	Unlike(content, `Unused`)
+	Unlike(content, `Unreachable`)
+	Unlike(content, `"fmt"`)
+	Unlike(content, `not used by anything`)
+	Is(result.kept, 7)
+	Is(result.decls, 11)
+
+	// An import, with -prune, into a host package (outside of GOPATH) that imports it as "./xyzzy"
+	host, err := ioutil.TempDir("", "smuggol.")
+	Is(err, nil)
+	defer os.RemoveAll(host)
+	ioutil.WriteFile(filepath.Join(host, "host.go"), []byte("package host\n\nimport \"./xyzzy\"\n\nvar _ = xyzzy.Used()\n"), 0666)
+	flag_quiet, flag_prune = true, true
+	defer func() {
+		flag_quiet, flag_prune = false, false
+	}()
+	mainName = "xyzzy-import"
+	Is(main(host, dir, nil), nil)
+	_, err = os.Stat(filepath.Join(host, "xyzzy", "unused.go"))
+	Is(os.IsNotExist(err), true)
+	pruned, err := ioutil.ReadFile(filepath.Join(host, "xyzzy", "xyzzy.go"))
+	Is(err, nil)
+	Like(string(pruned), `func Used\(\) \*Thing`)
+	Unlike(string(pruned), `Unused`)
+	lock, err := readLock(host)
+	Is(err, nil)
+	Is(len(lock.get("xyzzy").Files), 1)
+}
@@ -0,0 +1,265 @@
+package smuggol
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// _source is a resolved import package, ready to be copied
+type _source struct {
+	path     string // The import path, without any version (e.g. github.com/robertkrimen/terst)
+	version  string // The requested version (e.g. v1.2.3, a commit, or "" for whatever is there)
+	revision string // The (git) revision the package came from, if known
+	module   string // The module providing the package (when fetched from a proxy)
+	sum      string // The "h1:" hash of the module (when fetched from a proxy)
+	pkg      *build.Package
+	tmp      string // A temporary directory holding an extracted package, if any
+}
+
+// String returns the source as it appears in a generated header: path[@version]
+func (self _source) String() string {
+	if self.version == "" {
+		return self.path
+	}
+	return self.path + "@" + self.version
+}
+
+func (self _source) cleanup() {
+	if self.tmp != "" {
+		os.RemoveAll(self.tmp)
+	}
+}
+
+// splitVersion splits "github.com/robertkrimen/terst@v1.2.3" into path and version
+func splitVersion(src string) (string, string) {
+	if index := strings.LastIndex(src, "@"); index > 0 {
+		return src[:index], src[index+1:]
+	}
+	return src, ""
+}
+
+// resolve locates the import package given by src. With -proxy, the package is
+// downloaded from a module proxy (see resolveProxy). Otherwise, without a version, the package
+// is fetched (via get) and found in the usual place. With a version, the package is
+// extracted at that exact revision from a local git repository (see resolveGit)
+func resolve(ctx context.Context, src string) (*_source, error) {
+	path, version := splitVersion(src)
+	if flag_proxy != "" {
+		return resolveProxy(ctx, flag_proxy, path, version)
+	}
+	if version == "" {
+		// The package may well be there already (e.g. offline), so a failure only matters if it is not
+		fetch := get(ctx, src)
+
+		pkg, err := buildImport(src)
+		if err != nil {
+			if fetch != nil {
+				return nil, fail(ErrFetch, fmt.Errorf("go get %s: %s", src, fetch))
+			}
+			return nil, fail(ErrSourceNotFound, err)
+		}
+		return &_source{
+			path:     path,
+			revision: revision(pkg.Dir),
+			pkg:      pkg,
+		}, nil
+	}
+	return resolveGit(ctx, path, version)
+}
+
+// resolveGit extracts the package at path, as of version, from a local git repository:
+// either the repository given by -repo, or the one containing the package as it
+// currently exists on disk. Nothing is fetched and the working tree is left alone
+func resolveGit(ctx context.Context, importPath, version string) (*_source, error) {
+	repository, subdirectory := flag_repo, ""
+	if repository == "" {
+		pkg, err := buildImport(importPath)
+		if err != nil || pkg.Dir == "" {
+			return nil, fail(ErrSourceNotFound, fmt.Errorf("unable to find a local git repository for %s (try -repo)", importPath))
+		}
+		repository, err = git(pkg.Dir, "rev-parse", "--show-toplevel")
+		if err != nil {
+			return nil, fail(ErrSourceNotFound, fmt.Errorf("%s is not in a git repository (try -repo)", importPath))
+		}
+		dir, err := filepath.EvalSymlinks(pkg.Dir)
+		if err != nil {
+			return nil, err
+		}
+		subdirectory, err = filepath.Rel(repository, dir)
+		if err != nil {
+			return nil, err
+		}
+		subdirectory = filepath.ToSlash(subdirectory)
+		if subdirectory == "." {
+			subdirectory = ""
+		}
+	}
+
+	revision, err := git(repository, "rev-parse", "--verify", "--quiet", version+"^{commit}")
+	if err != nil {
+		return nil, fail(ErrSourceNotFound, fmt.Errorf("%s: unknown revision %q (in %s)", importPath, version, repository))
+	}
+
+	if flag_repo != "" {
+		subdirectory, err = repositorySubdirectory(repository, revision, importPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tmp, err := ioutil.TempDir("", "smuggol.")
+	if err != nil {
+		return nil, err
+	}
+	source := &_source{
+		path:     importPath,
+		version:  version,
+		revision: revision,
+		tmp:      tmp,
+	}
+
+	outputOf(ctx).emit(_event{Action: "fetch", Package: importPath, Version: version, Revision: revision, Dir: repository})
+	err = gitExtract(repository, revision, subdirectory, tmp)
+	if err != nil {
+		source.cleanup()
+		return nil, fail(ErrFetch, fmt.Errorf("%s: %s", source, err))
+	}
+	source.pkg, err = build.Default.ImportDir(tmp, 0)
+	if err != nil {
+		source.cleanup()
+		return nil, fail(ErrSourceNotFound, fmt.Errorf("%s: %s", source, err))
+	}
+	return source, nil
+}
+
+// repositorySubdirectory figures out where (in a repository, at revision) the package at importPath lives:
+// relative to the module path in go.mod, if there is one, or else as the longest
+// trailing part of importPath that is a directory in the repository
+func repositorySubdirectory(repository, revision, importPath string) (string, error) {
+	if goMod, err := git(repository, "show", revision+":go.mod"); err == nil {
+		scanner := bufio.NewScanner(strings.NewReader(goMod))
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 2 && fields[0] == "module" {
+				module := strings.Trim(fields[1], `"`)
+				if importPath == module {
+					return "", nil
+				}
+				if strings.HasPrefix(importPath, module+"/") {
+					return importPath[len(module)+1:], nil
+				}
+				return "", fail(ErrSourceNotFound, fmt.Errorf("%s is not in module %s (in %s)", importPath, module, repository))
+			}
+		}
+	}
+	parts := strings.Split(importPath, "/")
+	for index := range parts {
+		subdirectory := path.Join(parts[index:]...)
+		_, err := git(repository, "cat-file", "-e", revision+":"+subdirectory)
+		if err == nil {
+			return subdirectory, nil
+		}
+	}
+	return "", nil
+}
+
+// gitExtract writes the files (but not subdirectories) of subdirectory, as of revision, into dst,
+// along with the nearest license files (see _licenseFiles)
+func gitExtract(repository, revision, subdirectory, dst string) error {
+	arguments := []string{"archive", "--format=tar", revision}
+	if subdirectory != "" {
+		arguments = append(arguments, "--", subdirectory)
+	}
+	cmd := exec.Command("git", arguments...)
+	cmd.Dir = repository
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("git archive: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	want := subdirectory
+	if want == "" {
+		want = "."
+	}
+	archive := tar.NewReader(bytes.NewReader(output))
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if path.Dir(header.Name) != want {
+			continue
+		}
+		content, err := ioutil.ReadAll(archive)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(filepath.Join(dst, path.Base(header.Name)), content, 0666)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The archive is of subdirectory alone, so look for license files in the directories above it
+	licenses := newLicenseFiles(want)
+	for dir := want; dir != "."; {
+		dir = path.Dir(dir)
+		arguments := []string{"ls-tree", "--name-only", revision}
+		if dir != "." {
+			arguments = append(arguments, dir+"/")
+		}
+		list, err := git(repository, arguments...)
+		if err != nil {
+			return err
+		}
+		for _, name := range strings.Split(list, "\n") {
+			if !licenses.want(name) {
+				continue
+			}
+			cmd := exec.Command("git", "show", revision+":"+name)
+			cmd.Dir = repository
+			content, err := cmd.Output()
+			if err != nil {
+				return err
+			}
+			licenses.add(name, content)
+		}
+	}
+	return licenses.write(dst)
+}
+
+// git runs git in dir, returning its (trimmed) output
+func git(dir string, arguments ...string) (string, error) {
+	cmd := exec.Command("git", arguments...)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// revision returns the (git) revision of the repository containing dir, or ""
+func revision(dir string) string {
+	result, _ := git(dir, "rev-parse", "HEAD")
+	return result
+}
@@ -0,0 +1,109 @@
+package smuggol
+
+import (
+	"encoding/json"
+	. "github.com/robertkrimen/smuggol/terst"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveGit(t *testing.T) {
+	Terst(t)
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+
+	base, err := ioutil.TempDir("", "smuggol.")
+	Is(err, nil)
+	if err != nil {
+		FailNow()
+	}
+	defer os.RemoveAll(base)
+
+	repository := filepath.Join(base, "xyzzy")
+	write := func(name, content string) error {
+		path := filepath.Join(repository, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0777)
+		return ioutil.WriteFile(path, []byte(content), 0666)
+	}
+
+	first := ""
+	err = func() error {
+		os.MkdirAll(repository, 0777)
+		for _, arguments := range [][]string{
+			{"init", "-q"},
+			{"config", "user.email", "xyzzy@example.com"},
+			{"config", "user.name", "xyzzy"},
+		} {
+			if _, err := git(repository, arguments...); err != nil {
+				return err
+			}
+		}
+		write("go.mod", "module example.com/xyzzy\n")
+		write("xyzzy.go", "package xyzzy\n")
+		write("LICENSE", "Permission is hereby granted, free of charge, to any person obtaining a copy\n")
+		write("nothing/nothing.go", "package nothing\n\nconst Version = 1\n")
+		git(repository, "add", "-A")
+		git(repository, "commit", "-q", "-m", "1")
+		git(repository, "tag", "v1.0.0")
+		first, _ = git(repository, "rev-parse", "HEAD")
+		write("nothing/nothing.go", "package nothing\n\nconst Version = 2\n")
+		_, err := git(repository, "commit", "-q", "-a", "-m", "2")
+		return err
+	}()
+	Is(err, nil)
+	if err != nil {
+		FailNow()
+	}
+
+	flag_repo = repository
+	flag_quiet = true
+	defer func() {
+		flag_repo = ""
+		flag_quiet = false
+	}()
+
+	mainName = "nothing-import"
+	dst := filepath.Join(base, "host")
+	os.MkdirAll(dst, 0777)
+	err = main(dst, "example.com/xyzzy/nothing@v1.0.0", nil)
+	Is(err, nil)
+
+	content, err := ioutil.ReadFile(filepath.Join(dst, "nothing", "nothing.go"))
+	Is(err, nil)
+	Like(string(content), `^// Code generated by nothing-import \(smuggol\) from example.com/xyzzy/nothing@v1.0.0 \(`+first+`\)\. DO NOT EDIT\.\n`)
+	Like(string(content), `Version = 1`)
+
+	lock := _lock{}
+	content, err = ioutil.ReadFile(filepath.Join(dst, lockName))
+	Is(err, nil)
+	Is(json.Unmarshal(content, &lock), nil)
+	Is(len(lock.Imports), 1)
+	if len(lock.Imports) == 1 {
+		Is(lock.Imports[0].Name, "nothing")
+		Is(lock.Imports[0].Source, "example.com/xyzzy/nothing")
+		Is(lock.Imports[0].Version, "v1.0.0")
+		Is(lock.Imports[0].Revision, first)
+		Is(len(lock.Imports[0].Files), 1)
+		Is(lock.Imports[0].Licenses, map[string]string{"LICENSE": "MIT"})
+	}
+	_, err = os.Stat(filepath.Join(dst, "nothing", "LICENSE"))
+	Is(err, nil)
+
+	err = main(dst, "example.com/xyzzy/nothing@v9.9.9", nil)
+	Like(err, `unknown revision "v9.9.9"`)
+	Is(ExitCode(err), 4)
+
+	err = main(dst, "example.com/plugh/nothing@v1.0.0", nil)
+	Like(err, `example.com/plugh/nothing is not in module example.com/xyzzy`)
+	Is(ExitCode(err), 4)
+
+	flag_licenseDeny = "MIT"
+	err = main(dst, "example.com/xyzzy/nothing@v1.0.0", nil)
+	flag_licenseDeny = ""
+	Like(err, `MIT \(LICENSE\) is denied by -license-deny`)
+}
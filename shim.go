@@ -0,0 +1,123 @@
+package smuggol
+
+import (
+	"go/types"
+	"path"
+	"strings"
+)
+
+// A shim is an extra file, generated in the host package, that forwards each exported
+// func, const, and type of the import package to an unexported, host-level identifier:
+//
+//      var kiltGraveTrim = kilt.GraveTrim
+//      type kiltQuoteWord = kilt.QuoteWord
+//      const kiltVersion = kilt.Version
+//
+// Generic funcs and types cannot be forwarded this way, so they are skipped. If there is
+// nothing to forward, then no shim is written
+var shimTemplate = `
+package {{ .HostPackage }}
+
+import (
+	"{{ .ImportPath }}"
+)
+{{ with .Consts }}
+const (
+{{- range . }}
+	{{ camel $.ImportPackage .Name }} = {{ $.ImportPackage }}.{{ .Name }}
+{{- end }}
+)
+{{ end }}
+{{- with .Types }}
+type (
+{{- range . }}
+	{{ camel $.ImportPackage .Name }} = {{ $.ImportPackage }}.{{ .Name }}
+{{- end }}
+)
+{{ end }}
+{{- with .Funcs }}
+var (
+{{- range . }}
+	{{ camel $.ImportPackage .Name }} = {{ $.ImportPackage }}.{{ .Name }}
+{{- end }}
+)
+{{ end }}`
+
+// shimName is the name of the shim file in the host package (e.g. kilt_shim.go)
+func shimName(name string) string {
+	return name + "_shim.go"
+}
+
+// shimExports filters exports down to what is forwarded by the shim: funcs, consts,
+// and types that are not generic and that pass the include/exclude patterns (path.Match)
+func shimExports(exports []export, include, exclude []string) ([]export, error) {
+	match := func(patterns []string, name string) (bool, error) {
+		for _, pattern := range patterns {
+			matched, err := path.Match(pattern, name)
+			if err != nil {
+				return false, err
+			}
+			if matched {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	result := []export{}
+	for _, export := range exports {
+		switch export.Kind {
+		case "func", "const", "type":
+		default:
+			continue
+		}
+		if export.generic {
+			continue
+		}
+		if len(include) > 0 {
+			matched, err := match(include, export.Name)
+			if err != nil {
+				return nil, err
+			}
+			if !matched {
+				continue
+			}
+		}
+		matched, err := match(exclude, export.Name)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			continue
+		}
+		result = append(result, export)
+	}
+	return result, nil
+}
+
+// isGeneric reports whether object is a func or type with type parameters
+func isGeneric(object types.Object) bool {
+	switch object := object.(type) {
+	case *types.Func:
+		if signature, ok := object.Type().(*types.Signature); ok {
+			return signature.TypeParams().Len() > 0
+		}
+	case *types.TypeName:
+		if named, ok := object.Type().(*types.Named); ok {
+			return named.TypeParams().Len() > 0
+		}
+	}
+	return false
+}
+
+// splitList splits a comma-separated flag value, ignoring empty entries
+func splitList(value string) []string {
+	result := []string{}
+	for _, value := range strings.Split(value, ",") {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
@@ -0,0 +1,85 @@
+package smuggol
+
+import (
+	. "github.com/robertkrimen/smuggol/terst"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestShim(t *testing.T) {
+	Terst(t)
+
+	base, err := ioutil.TempDir("", "smuggol.")
+	Is(err, nil)
+	if err != nil {
+		FailNow()
+	}
+	defer os.RemoveAll(base)
+
+	source := filepath.Join(base, "xyzzy")
+	os.MkdirAll(source, 0777)
+	ioutil.WriteFile(filepath.Join(source, "xyzzy.go"), []byte(kiltGraveTrim(`
+package xyzzy
+
+const Version = "1.0"
+
+var Debug = false
+
+type Thing struct{}
+
+type Box[T any] struct{ Value T }
+
+func GraveTrim(target string) string { return target }
+
+func Sha1(target string) string { return target }
+
+func Map[T any](value T) T { return value }
+    `)), 0666)
+
+	checked, err := typeCheck("example.com/xyzzy", source, []string{"xyzzy.go"})
+	Is(err, nil)
+	names := func(exports []export) []string {
+		result := []string{}
+		for _, export := range exports {
+			result = append(result, export.Name)
+		}
+		return result
+	}
+
+	// Generic funcs and types (and vars) are not forwarded
+	forwarded, err := shimExports(exports(checked), nil, nil)
+	Is(err, nil)
+	Is(names(forwarded), []string{"GraveTrim", "Sha1", "Thing", "Version"})
+
+	forwarded, err = shimExports(exports(checked), []string{"G*", "S*", "Map"}, []string{"Sha1"})
+	Is(err, nil)
+	Is(names(forwarded), []string{"GraveTrim"})
+
+	_, err = shimExports(exports(checked), []string{"["}, nil)
+	Like(err, `syntax error in pattern`)
+
+	flag_quiet, flag_shim, flag_shimExclude = true, true, "Sha1"
+	defer func() {
+		flag_quiet, flag_shim, flag_shimInclude, flag_shimExclude = false, false, "", ""
+	}()
+	mainName = "xyzzy-import"
+	dst := filepath.Join(base, "host")
+	os.MkdirAll(dst, 0777)
+	ioutil.WriteFile(filepath.Join(dst, "host.go"), []byte("package host\n"), 0666)
+	Is(main(dst, source, nil), nil)
+	content, err := ioutil.ReadFile(filepath.Join(dst, "xyzzy_shim.go"))
+	Is(err, nil)
+	Like(string(content), `\n\txyzzyGraveTrim = xyzzy\.GraveTrim\n`)
+	Like(string(content), `\n\txyzzyThing = xyzzy\.Thing\n`)
+	Like(string(content), `\n\txyzzyVersion = xyzzy\.Version\n`)
+	Unlike(string(content), `Sha1|Map|Box|Debug`)
+
+	// With nothing to forward, the shim is removed
+	flag_shimInclude = "Nothing*"
+	Is(main(dst, source, nil), nil)
+	_, err = os.Stat(filepath.Join(dst, "xyzzy_shim.go"))
+	Is(os.IsNotExist(err), true)
+	Is(main(dst, source, nil), nil)
+}
@@ -2,6 +2,8 @@ package smuggol
 
 import (
 	"go/build"
+	"go/parser"
+	"go/token"
 	"os"
 	"path/filepath"
 	"unicode"
@@ -100,3 +102,21 @@ func kiltGraveTrim(target string) string {
 	result := target[start : stop+1]
 	return result
 }
+
+// renamePackage rewrites the package clause of a Go source file, leaving
+// everything else (comments, formatting, etc.) untouched
+func renamePackage(src []byte, name string) ([]byte, error) {
+	fileSet := token.NewFileSet()
+	file, err := parser.ParseFile(fileSet, "", src, parser.PackageClauseOnly)
+	if err != nil {
+		return nil, err
+	}
+	start := fileSet.Position(file.Name.Pos()).Offset
+	end := fileSet.Position(file.Name.End()).Offset
+
+	result := make([]byte, 0, len(src)+len(name))
+	result = append(result, src[:start]...)
+	result = append(result, name...)
+	result = append(result, src[end:]...)
+	return result, nil
+}
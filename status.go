@@ -0,0 +1,383 @@
+package smuggol
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// _status is what "status" reports for a single smuggled package
+type _status struct {
+	Dir      string        `json:"dir"`                // The directory of the subordinate package
+	Source   string        `json:"source"`             // The import path it came from
+	Version  string        `json:"version,omitempty"`  // The recorded version
+	Revision string        `json:"revision,omitempty"` // The recorded revision
+	Latest   string        `json:"latest,omitempty"`   // The latest upstream version (or revision)
+	Newer    []string      `json:"newer,omitempty"`    // Upstream versions newer than the recorded version
+	Files    []_fileChange `json:"files,omitempty"`    // What an update to latest would change
+	Error    string        `json:"error,omitempty"`
+}
+
+// _fileChange is a change to a single file: A(dded), M(odified), or D(eleted)
+type _fileChange struct {
+	Name    string `json:"name"`
+	Change  string `json:"change"`
+	Added   int    `json:"added"`   // Lines added
+	Removed int    `json:"removed"` // Lines removed
+}
+
+func (self _fileChange) String() string {
+	switch self.Change {
+	case "M":
+		return fmt.Sprintf("M %s (+%d -%d)", self.Name, self.Added, self.Removed)
+	}
+	return fmt.Sprintf("%s %s", self.Change, self.Name)
+}
+
+// findLocks returns the directory of every lockfile under root, skipping vendor,
+// testdata, and hidden directories
+func findLocks(root string) ([]string, error) {
+	result := []string{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != root && skipDir(info.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Name() == lockName {
+			result = append(result, filepath.Dir(path))
+		}
+		return nil
+	})
+	return result, err
+}
+
+// skipDir reports whether a walk should skip the directory with the given name
+func skipDir(name string) bool {
+	return name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")
+}
+
+// defaultProxy is where status (and friends) look for module versions when there is no
+// -proxy: the local module cache, as a file:// proxy, so nothing is downloaded
+func defaultProxy() string {
+	if flag_proxy != "" {
+		return flag_proxy
+	}
+	cache := os.Getenv("GOMODCACHE")
+	if cache == "" {
+		gopath := filepath.SplitList(build.Default.GOPATH)
+		if len(gopath) == 0 {
+			return ""
+		}
+		cache = filepath.Join(gopath[0], "pkg", "mod")
+	}
+	return "file://" + filepath.ToSlash(filepath.Join(cache, "cache", "download"))
+}
+
+// upstream finds the latest version of entry (and any versions newer than the recorded one)
+func upstream(entry _lockImport) (latest string, newer []string, err error) {
+	switch {
+	case entry.Module != "":
+		proxy := newProxy(context.Background(), defaultProxy())
+		versions, err := proxy.list(entry.Module)
+		if err != nil && err != errNotFound {
+			return "", nil, err
+		}
+		for _, version := range versions {
+			if compareVersion(version, entry.Version) > 0 {
+				newer = append(newer, version)
+			}
+		}
+		if len(newer) > 0 {
+			return newer[len(newer)-1], newer, nil
+		}
+		return entry.Version, nil, nil
+
+	case entry.Version != "":
+		repository, err := sourceRepository(entry.Source)
+		if err != nil {
+			return "", nil, err
+		}
+		if validVersion(entry.Version) {
+			tags, _ := git(repository, "tag", "--list", "v*")
+			for _, tag := range strings.Fields(tags) {
+				if validVersion(tag) && compareVersion(tag, entry.Version) > 0 {
+					newer = append(newer, tag)
+				}
+			}
+			sort.Slice(newer, func(i, j int) bool {
+				return compareVersion(newer[i], newer[j]) < 0
+			})
+			if len(newer) > 0 {
+				return newer[len(newer)-1], newer, nil
+			}
+			return entry.Version, nil, nil
+		}
+		head, err := git(repository, "rev-parse", "HEAD")
+		if err != nil {
+			return "", nil, err
+		}
+		if head != entry.Revision {
+			newer = append(newer, head)
+		}
+		return head, newer, nil
+	}
+
+	pkg, err := buildImport(entry.Source)
+	if err != nil {
+		return "", nil, err
+	}
+	head := revision(pkg.Dir)
+	if head != "" && head != entry.Revision {
+		newer = append(newer, head)
+	}
+	return head, newer, nil
+}
+
+// sourceRepository returns the local git repository for importPath: -repo, or the one containing it
+func sourceRepository(importPath string) (string, error) {
+	if flag_repo != "" {
+		return flag_repo, nil
+	}
+	pkg, err := buildImport(importPath)
+	if err != nil || pkg.Dir == "" {
+		return "", fmt.Errorf("unable to find a local git repository for %s (try -repo)", importPath)
+	}
+	return git(pkg.Dir, "rev-parse", "--show-toplevel")
+}
+
+// fetchAt resolves the source of entry at version (or, for an unversioned entry, at a revision
+// of its local git repository, or as it is if version is empty), leaving out the files that
+// entry leaves out (see _fileFilter). Nothing is printed (e.g. fetch events, with -json)
+func fetchAt(entry _lockImport, version string) (*_source, error) {
+	filter, err := newFileFilter(entry.Include, entry.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	ctx := withOutput(context.Background(), &_output{buffer: true})
+	source := &_source{}
+	switch {
+	case entry.Module != "":
+		source, err = resolveProxy(ctx, defaultProxy(), entry.Source, version)
+	case version != "":
+		source, err = resolveGit(ctx, entry.Source, version)
+	default:
+		source.path = entry.Source
+		source.pkg, err = buildImport(entry.Source)
+		if err == nil {
+			source.revision = revision(source.pkg.Dir)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	source.pkg.GoFiles = filter.filter(source.pkg.GoFiles)
+	return source, nil
+}
+
+// readPackage reads the .go files of a package, keyed by name
+func readPackage(pkg *build.Package) (map[string][]byte, error) {
+	result := map[string][]byte{}
+	for _, name := range pkg.GoFiles {
+		content, err := ioutil.ReadFile(filepath.Join(pkg.Dir, name))
+		if err != nil {
+			return nil, err
+		}
+		result[name] = content
+	}
+	return result, nil
+}
+
+// diffFiles summarizes the changes from before to after
+func diffFiles(before, after map[string][]byte) []_fileChange {
+	result := []_fileChange{}
+	for name, content := range after {
+		previous, exists := before[name]
+		switch {
+		case !exists:
+			result = append(result, _fileChange{Name: name, Change: "A", Added: countLines(content)})
+		case !bytes.Equal(previous, content):
+			added, removed := diffLines(previous, content)
+			result = append(result, _fileChange{Name: name, Change: "M", Added: added, Removed: removed})
+		}
+	}
+	for name, content := range before {
+		if _, exists := after[name]; !exists {
+			result = append(result, _fileChange{Name: name, Change: "D", Removed: countLines(content)})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+func splitLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
+
+func countLines(content []byte) int {
+	return len(splitLines(content))
+}
+
+// diffLines counts the lines added and removed between a and b (via a longest common subsequence)
+func diffLines(a, b []byte) (added, removed int) {
+	x, y := splitLines(a), splitLines(b)
+	for len(x) > 0 && len(y) > 0 && x[0] == y[0] {
+		x, y = x[1:], y[1:]
+	}
+	for len(x) > 0 && len(y) > 0 && x[len(x)-1] == y[len(y)-1] {
+		x, y = x[:len(x)-1], y[:len(y)-1]
+	}
+	if len(x)*len(y) > 16*1024*1024 {
+		return len(y), len(x) // Too big to be precise
+	}
+	previous := make([]int, len(y)+1)
+	current := make([]int, len(y)+1)
+	for i := 1; i <= len(x); i++ {
+		for j := 1; j <= len(y); j++ {
+			switch {
+			case x[i-1] == y[j-1]:
+				current[j] = previous[j-1] + 1
+			case previous[j] >= current[j-1]:
+				current[j] = previous[j]
+			default:
+				current[j] = current[j-1]
+			}
+		}
+		previous, current = current, previous
+	}
+	common := previous[len(y)]
+	return len(y) - common, len(x) - common
+}
+
+// statusOf works out the status of a single smuggled package (in dir)
+func statusOf(dir string, entry _lockImport) _status {
+	self := _status{
+		Dir:      dir,
+		Source:   entry.Source,
+		Version:  entry.Version,
+		Revision: entry.Revision,
+	}
+	latest, newer, err := upstream(entry)
+	if err != nil {
+		self.Error = err.Error()
+		return self
+	}
+	self.Latest, self.Newer = latest, newer
+	if len(newer) == 0 {
+		return self
+	}
+
+	// What would an update bring in? Compare upstream as of our version (or revision) with
+	// upstream as of latest. Not with our copy, which is whatever the import made of upstream
+	// (with a header, another package name, pruned, ...)
+	recorded := entry.Version
+	if recorded == "" {
+		recorded = entry.Revision
+	}
+	if recorded == "" {
+		return self
+	}
+	source, err := fetchAt(entry, recorded)
+	if err != nil {
+		self.Error = err.Error()
+		return self
+	}
+	before, err := readPackage(source.pkg)
+	source.cleanup()
+	if err != nil {
+		self.Error = err.Error()
+		return self
+	}
+	source, err = fetchAt(entry, latest)
+	if err != nil {
+		self.Error = err.Error()
+		return self
+	}
+	defer source.cleanup()
+	after, err := readPackage(source.pkg)
+	if err != nil {
+		self.Error = err.Error()
+		return self
+	}
+	self.Files = diffFiles(before, after)
+	return self
+}
+
+// mainStatus reports on every smuggled package under root
+func mainStatus(root string, output io.Writer) error {
+	if root == "" {
+		root = "."
+	}
+	dirs, err := findLocks(root)
+	if err != nil {
+		return err
+	}
+	result := []_status{}
+	for _, dir := range dirs {
+		lock, err := readLock(dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range lock.Imports {
+			result = append(result, statusOf(filepath.Join(dir, entry.Name), entry))
+		}
+	}
+
+	if flag_json {
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "    ")
+		return encoder.Encode(result)
+	}
+
+	for _, status := range result {
+		source := status.Source
+		if status.Version != "" {
+			source += "@" + status.Version
+		}
+		if status.Revision != "" && status.Revision != status.Version {
+			source += " (" + shortRevision(status.Revision) + ")"
+		}
+		fmt.Fprintf(output, "%s\t%s\n", status.Dir, source)
+		switch {
+		case status.Error != "":
+			fmt.Fprintf(output, "    error: %s\n", status.Error)
+		case len(status.Newer) == 0:
+			fmt.Fprintf(output, "    up to date\n")
+		default:
+			newer := []string{}
+			for _, version := range status.Newer {
+				newer = append(newer, shortRevision(version))
+			}
+			fmt.Fprintf(output, "    newer: %s (latest %s)\n", strings.Join(newer, ", "), shortRevision(status.Latest))
+			for _, file := range status.Files {
+				fmt.Fprintf(output, "    %s\n", file)
+			}
+		}
+	}
+	return nil
+}
+
+// shortRevision abbreviates a (40 character) git revision
+func shortRevision(revision string) string {
+	if len(revision) == 40 && !strings.HasPrefix(revision, "v") {
+		return revision[:12]
+	}
+	return revision
+}
@@ -0,0 +1,126 @@
+package smuggol
+
+import (
+	"bytes"
+	"encoding/json"
+	. "github.com/robertkrimen/smuggol/terst"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestStatus(t *testing.T) {
+	Terst(t)
+
+	added, removed := diffLines([]byte("a\nb\nc\nd\n"), []byte("a\nx\nc\nd\ne\n"))
+	Is(added, 2)
+	Is(removed, 1)
+
+	added, removed = diffLines([]byte("a\nb\n"), []byte("a\nb\n"))
+	Is(added, 0)
+	Is(removed, 0)
+
+	changes := diffFiles(map[string][]byte{
+		"same.go":    []byte("package xyzzy\n"),
+		"changed.go": []byte("package xyzzy\n\nconst Version = 1\n"),
+		"deleted.go": []byte("package xyzzy\n\n"),
+	}, map[string][]byte{
+		"same.go":    []byte("package xyzzy\n"),
+		"changed.go": []byte("package xyzzy\n\nconst Version = 2\n"),
+		"added.go":   []byte("package xyzzy\n"),
+	})
+	Is(len(changes), 3)
+	if len(changes) == 3 {
+		Is(changes[0].String(), "A added.go")
+		Is(changes[1].String(), "M changed.go (+1 -1)")
+		Is(changes[2].String(), "D deleted.go")
+	}
+
+	Is(shortRevision("3ff95df6033f176e5da9eef42a1b102ee25ac8e3"), "3ff95df6033f")
+	Is(shortRevision("v1.2.3"), "v1.2.3")
+}
+
+func TestMainStatus(t *testing.T) {
+	Terst(t)
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+
+	base, err := ioutil.TempDir("", "smuggol.")
+	Is(err, nil)
+	if err != nil {
+		FailNow()
+	}
+	defer os.RemoveAll(base)
+
+	repository := filepath.Join(base, "xyzzy")
+	write := func(name, content string) {
+		path := filepath.Join(repository, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0777)
+		ioutil.WriteFile(path, []byte(content), 0666)
+	}
+	os.MkdirAll(repository, 0777)
+	for _, arguments := range [][]string{
+		{"init", "-q"},
+		{"config", "user.email", "xyzzy@example.com"},
+		{"config", "user.name", "xyzzy"},
+	} {
+		_, err := git(repository, arguments...)
+		Is(err, nil)
+	}
+	write("xyzzy.go", "package xyzzy\n\nfunc Xyzzy() string { return Name }\n")
+	write("name.go", "package xyzzy\n\nconst Name = \"xyzzy\"\n")
+	git(repository, "add", "-A")
+	git(repository, "commit", "-q", "-m", "1")
+
+	// An (unversioned) import from the repository, under another name and with a build
+	// constraint, so that the copy is nothing like upstream
+	flag_quiet, flag_build = true, "debug"
+	defer func() {
+		flag_quiet, flag_build = false, ""
+	}()
+	mainName = "xyzzy-import"
+	dst := filepath.Join(base, "host")
+	os.MkdirAll(dst, 0777)
+	Is(main(dst, repository, nil), nil)
+
+	output := &bytes.Buffer{}
+	Is(mainStatus(dst, output), nil)
+	Like(output.String(), `xyzzy\t.*xyzzy \([0-9a-f]{12}\)\n    up to date\n$`)
+
+	// Upstream moves on: only name.go changes
+	write("name.go", "package xyzzy\n\nconst Name = \"plugh\"\n")
+	_, err = git(repository, "commit", "-q", "-a", "-m", "2")
+	Is(err, nil)
+	head := revision(repository)
+
+	output.Reset()
+	Is(mainStatus(dst, output), nil)
+	Like(output.String(), `\n    newer: `+head[:12]+` \(latest `+head[:12]+`\)\n    M name\.go \(\+1 -1\)\n$`)
+
+	flag_json = true
+	defer func() {
+		flag_json = false
+	}()
+	// ...and nothing else (e.g. fetch events) on standard output
+	stdout, _ := ioutil.TempFile(base, "stdout.")
+	os.Stdout, stdout = stdout, os.Stdout
+	Is(mainStatus(dst, os.Stdout), nil)
+	os.Stdout, stdout = stdout, os.Stdout
+	stdout.Close()
+	content, _ := ioutil.ReadFile(stdout.Name())
+	result := []_status{}
+	Is(json.Unmarshal(content, &result), nil)
+	Is(len(result), 1)
+	if len(result) == 1 {
+		Is(result[0].Dir, filepath.Join(dst, "xyzzy"))
+		Is(result[0].Source, repository)
+		Is(result[0].Latest, head)
+		Is(result[0].Newer, []string{head})
+		Is(result[0].Files, []_fileChange{{Name: "name.go", Change: "M", Added: 1, Removed: 1}})
+		Is(result[0].Error, "")
+	}
+}
@@ -0,0 +1,295 @@
+package smuggol
+
+import (
+	"bytes"
+	"fmt"
+	"go/types"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+)
+
+// templateData is what an extra template is executed with
+type templateData struct {
+	HostPackage   string    // The name of the host package
+	ImportPath    string    // The import path to the new import package
+	ImportPackage string    // The name of the new import package
+	SourcePath    string    // The import path of the original (source) package
+	Revision      string    // The revision of the source package, if known
+	Files         []string  // The files copied into the new import package
+	Exports       []export  // The exported identifiers of the new import package
+	Time          time.Time // When the import happened
+	Tool          string    // The name of the application doing the import
+}
+
+// Funcs returns the exported functions of the import package
+func (self templateData) Funcs() []export { return self.exportKind("func") }
+
+// Types returns the exported types of the import package
+func (self templateData) Types() []export { return self.exportKind("type") }
+
+// Vars returns the exported variables of the import package
+func (self templateData) Vars() []export { return self.exportKind("var") }
+
+// Consts returns the exported constants of the import package
+func (self templateData) Consts() []export { return self.exportKind("const") }
+
+func (self templateData) exportKind(kind string) []export {
+	result := []export{}
+	for _, export := range self.Exports {
+		if export.Kind == kind {
+			result = append(result, export)
+		}
+	}
+	return result
+}
+
+// export is an exported, package-level identifier
+type export struct {
+	Name      string // GraveTrim
+	Kind      string // func, type, var, or const
+	Signature string // func(target string) string
+	generic   bool
+}
+
+func (self export) String() string {
+	return self.Name
+}
+
+// exports lists the exported, package-level identifiers of a type-checked package,
+// sorted by name
+func exports(checked *_checked) []export {
+	result := []export{}
+	if checked.pkg == nil {
+		return result
+	}
+	scope := checked.pkg.Scope()
+	qualifier := func(pkg *types.Package) string {
+		if pkg == checked.pkg {
+			return ""
+		}
+		return pkg.Name()
+	}
+	for _, name := range scope.Names() { // Names is already sorted
+		object := scope.Lookup(name)
+		if !object.Exported() {
+			continue
+		}
+		export := export{
+			Name:    name,
+			generic: isGeneric(object),
+		}
+		switch object := object.(type) {
+		case *types.Func:
+			export.Kind = "func"
+			export.Signature = types.TypeString(object.Type(), qualifier)
+		case *types.TypeName:
+			export.Kind = "type"
+			export.Signature = types.TypeString(object.Type().Underlying(), qualifier)
+		case *types.Var:
+			export.Kind = "var"
+			export.Signature = types.TypeString(object.Type(), qualifier)
+		case *types.Const:
+			export.Kind = "const"
+			export.Signature = types.TypeString(object.Type(), qualifier)
+		default:
+			continue
+		}
+		result = append(result, export)
+	}
+	return result
+}
+
+// camel joins its arguments into a single camelCase identifier:
+//
+//      camel "kilt" "GraveTrim"    # kiltGraveTrim
+//      camel "go-kilt" "new"       # goKiltNew
+//
+func camel(values ...string) string {
+	result := []rune{}
+	for _, value := range values {
+		upper := len(result) > 0
+		for _, chr := range value {
+			if !unicode.IsLetter(chr) && !unicode.IsDigit(chr) {
+				upper = len(result) > 0
+				continue
+			}
+			switch {
+			case len(result) == 0:
+				chr = unicode.ToLower(chr)
+			case upper:
+				chr = unicode.ToUpper(chr)
+			}
+			upper = false
+			result = append(result, chr)
+		}
+	}
+	return string(result)
+}
+
+var templateFuncMap = template.FuncMap{
+	"lower":     strings.ToLower,
+	"upper":     strings.ToUpper,
+	"camel":     camel,
+	"join":      func(values []string, separator string) string { return strings.Join(values, separator) },
+	"quote":     strconv.Quote,
+	"GraveTrim": kiltGraveTrim,
+}
+
+// _template is an extra file to be rendered into the host package
+type _template struct {
+	name    string // The path of the file to write, relative to the host package
+	source  string // The name of the template, used for error reporting
+	text    string
+	when    string // The "if:" front matter (a pipeline), if any
+	partial bool   // A partial is only available via {{ template }}, it is not written
+}
+
+// loadTemplates returns the extra templates described by extra, which is one of:
+//
+//      map[string]string   # Each key/value pair is a file name and template
+//      string              # A directory of *.tmpl files
+//      fs.FS               # ...as above, e.g. an embed.FS
+//
+// Every *.tmpl file (in any subdirectory) becomes a file in the host package, minus
+// the .tmpl suffix. A file with a name beginning with "_" is a partial
+func loadTemplates(extra interface{}) ([]_template, error) {
+	switch extra := extra.(type) {
+	case nil:
+		return nil, nil
+	case map[string]string:
+		result := []_template{}
+		for name, text := range extra {
+			result = append(result, _template{
+				name:   name,
+				source: name,
+				text:   kiltGraveTrim(text),
+			})
+		}
+		sort.Slice(result, func(i, j int) bool {
+			return result[i].name < result[j].name
+		})
+		return result, nil
+	case string:
+		if extra == "" {
+			return nil, nil
+		}
+		result, err := loadTemplateFS(os.DirFS(extra))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", extra, err)
+		}
+		return result, nil
+	case fs.FS:
+		return loadTemplateFS(extra)
+	}
+	return nil, fmt.Errorf("invalid extra (%T), expected map[string]string, string, or fs.FS", extra)
+}
+
+func loadTemplateFS(fsys fs.FS) ([]_template, error) {
+	result := []_template{}
+	err := fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !strings.HasSuffix(name, ".tmpl") {
+			return nil
+		}
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		tmpl := _template{
+			name:    strings.TrimSuffix(name, ".tmpl"),
+			source:  name,
+			partial: strings.HasPrefix(path.Base(name), "_"),
+		}
+		tmpl.text, tmpl.when, err = frontMatter(name, string(content))
+		if err != nil {
+			return err
+		}
+		result = append(result, tmpl)
+		return nil
+	})
+	return result, err
+}
+
+// frontMatter separates an (optional) front matter block from the rest of a template:
+//
+//      ---
+//      if: .Funcs
+//      ---
+//      package {{ .HostPackage }}
+//      ...
+//
+// If the "if" pipeline is false (or empty), then the file is skipped. To keep line
+// numbers in error messages accurate, the front matter is replaced with empty actions
+func frontMatter(source, text string) (body, when string, err error) {
+	const delimiter = "---"
+	lines := strings.SplitAfter(text, "\n")
+	if len(lines) == 0 || strings.TrimSpace(lines[0]) != delimiter {
+		return text, "", nil
+	}
+	for index := 1; index < len(lines); index++ {
+		line := strings.TrimSpace(lines[index])
+		if line == delimiter {
+			blank := strings.Repeat("{{- /* front matter */ -}}\n", index+1)
+			return blank + strings.Join(lines[index+1:], ""), when, nil
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		colon := strings.Index(line, ":")
+		if colon == -1 {
+			return "", "", fmt.Errorf("%s:%d: invalid front matter: %q", source, index+1, line)
+		}
+		key, value := strings.TrimSpace(line[:colon]), strings.TrimSpace(line[colon+1:])
+		switch key {
+		case "if":
+			when = value
+		default:
+			return "", "", fmt.Errorf("%s:%d: unknown front matter %q", source, index+1, key)
+		}
+	}
+	return "", "", fmt.Errorf("%s:1: unterminated front matter", source)
+}
+
+// parseTemplates parses every template into a single set, so that any template
+// can use another (e.g. a partial) via {{ template "_header.go.tmpl" . }}
+func parseTemplates(templates []_template) (*template.Template, error) {
+	root := template.New("").Funcs(templateFuncMap)
+	for _, tmpl := range templates {
+		_, err := root.New(tmpl.source).Parse(tmpl.text)
+		if err != nil {
+			return nil, err
+		}
+		if tmpl.when != "" {
+			_, err := root.New(tmpl.source + ":if").Parse("{{ if " + tmpl.when + " }}true{{ end }}")
+			if err != nil {
+				return nil, fmt.Errorf("%s: if: %s", tmpl.source, err)
+			}
+		}
+	}
+	return root, nil
+}
+
+// skip reports whether the template should not be written, given its "if" front matter
+func (self _template) skip(root *template.Template, data interface{}) (bool, error) {
+	if self.partial {
+		return true, nil
+	}
+	if self.when == "" {
+		return false, nil
+	}
+	var buffer bytes.Buffer
+	err := root.ExecuteTemplate(&buffer, self.source+":if", data)
+	if err != nil {
+		return false, err
+	}
+	return buffer.String() != "true", nil
+}
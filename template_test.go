@@ -0,0 +1,214 @@
+package smuggol
+
+import (
+	"bytes"
+	"fmt"
+	. "github.com/robertkrimen/smuggol/terst"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"text/template"
+	"time"
+)
+
+func TestTemplate(t *testing.T) {
+	Terst(t)
+
+	loadAndParse := func(fsys fstest.MapFS) (*template.Template, error) {
+		templates, err := loadTemplates(fsys)
+		if err != nil {
+			return nil, err
+		}
+		return parseTemplates(templates)
+	}
+
+	fsys := fstest.MapFS{
+		"xyzzy.go.tmpl": &fstest.MapFile{Data: []byte(kiltGraveTrim(`
+---
+if: .Funcs
+---
+package {{ .HostPackage }}
+{{ template "_import.go.tmpl" . }}
+        `))},
+		"_import.go.tmpl":    &fstest.MapFile{Data: []byte(`import "{{ .ImportPath }}"`)},
+		"nested/README.tmpl": &fstest.MapFile{Data: []byte(`{{ upper .ImportPackage }}`)},
+		"ignore.go":          &fstest.MapFile{Data: []byte(`package ignore`)},
+	}
+
+	templates, err := loadTemplates(fsys)
+	Is(err, nil)
+	Is(len(templates), 3)
+
+	root, err := parseTemplates(templates)
+	Is(err, nil)
+
+	data := templateData{
+		HostPackage:   "xyzzy",
+		ImportPath:    "./kilt",
+		ImportPackage: "kilt",
+	}
+
+	for _, tmpl := range templates {
+		switch tmpl.name {
+		case "_import.go":
+			skip, _ := tmpl.skip(root, data)
+			Is(skip, true)
+		case "nested/README":
+			skip, _ := tmpl.skip(root, data)
+			Is(skip, false)
+		case "xyzzy.go":
+			skip, err := tmpl.skip(root, data)
+			Is(err, nil)
+			Is(skip, true)
+
+			data.Exports = []export{{Name: "GraveTrim", Kind: "func"}}
+			skip, err = tmpl.skip(root, data)
+			Is(err, nil)
+			Is(skip, false)
+			var buffer bytes.Buffer
+			Is(root.Lookup(tmpl.source).Execute(&buffer, data), nil)
+			Is(buffer.String(), "package xyzzy\nimport \"./kilt\"\n")
+		default:
+			Fail(tmpl.name)
+		}
+	}
+
+	// Line numbers account for the front matter
+	_, err = loadAndParse(fstest.MapFS{
+		"bad.go.tmpl": &fstest.MapFile{Data: []byte("---\nif: true\n---\npackage xyzzy\n{{ .Missing\n")},
+	})
+	Like(err, `started at bad\.go\.tmpl:5$`)
+
+	_, err = loadAndParse(fstest.MapFS{
+		"bad.go.tmpl": &fstest.MapFile{Data: []byte("---\nunless: true\n---\n")},
+	})
+	Like(err, `bad\.go\.tmpl:2: unknown front matter "unless"`)
+
+	Is(camel("kilt", "GraveTrim"), "kiltGraveTrim")
+	Is(camel("go-kilt", "new"), "goKiltNew")
+}
+
+func TestTemplateData(t *testing.T) {
+	Terst(t)
+
+	base, err := ioutil.TempDir("", "smuggol.")
+	Is(err, nil)
+	if err != nil {
+		FailNow()
+	}
+	defer os.RemoveAll(base)
+
+	ioutil.WriteFile(filepath.Join(base, "kilt.go"), []byte(kiltGraveTrim(`
+package kilt
+
+import "time"
+
+const Version = "1.0"
+
+var Debug bool
+
+type Thing struct {
+	Name string
+	When time.Time
+}
+
+type hidden int
+
+func GraveTrim(target string) string { return target }
+
+func Now(thing *Thing) (time.Time, error) { return thing.When, nil }
+    `)), 0666)
+	checked, err := typeCheck("example.com/kilt", base, []string{"kilt.go"})
+	Is(err, nil)
+
+	// Sorted by name, with signatures qualified by package (except this one)
+	signatures := []string{}
+	for _, export := range exports(checked) {
+		signatures = append(signatures, export.Kind+" "+export.Name+" "+export.Signature)
+	}
+	Is(strings.Join(signatures, "\n"), strings.Join([]string{
+		"var Debug bool",
+		"func GraveTrim func(target string) string",
+		"func Now func(thing *Thing) (time.Time, error)",
+		"type Thing struct{Name string; When time.Time}",
+		"const Version untyped string",
+	}, "\n"))
+
+	data := templateData{
+		HostPackage:   "xyzzy",
+		ImportPath:    "./kilt",
+		ImportPackage: "kilt",
+		SourcePath:    "github.com/robertkrimen/kilt",
+		Revision:      "3ff95df6033f176e5da9eef42a1b102ee25ac8e3",
+		Files:         []string{"kilt.go", "grave.go"},
+		Exports:       exports(checked),
+		Time:          time.Date(2014, 1, 2, 3, 4, 5, 0, time.UTC),
+		Tool:          "kilt-import",
+	}
+	Is(fmt.Sprint(data.Funcs()), "[GraveTrim Now]")
+	Is(fmt.Sprint(data.Types()), "[Thing]")
+	Is(fmt.Sprint(data.Vars()), "[Debug]")
+	Is(fmt.Sprint(data.Consts()), "[Version]")
+
+	tmpl, err := template.New("xyzzy").Funcs(templateFuncMap).Parse(kiltGraveTrim(`
+{{ upper .ImportPackage }} {{ lower .Tool }} {{ quote .SourcePath }} {{ .Revision }} {{ .Time.Year }}
+{{ join .Files ", " }}
+{{ range .Funcs }}{{ camel $.ImportPackage .Name }} {{ .Signature }}
+{{ end }}{{ GraveTrim "\nxyzzy  " }}
+    `))
+	Is(err, nil)
+	var buffer bytes.Buffer
+	Is(tmpl.Execute(&buffer, data), nil)
+	Is(buffer.String(), kiltGraveTrim(`
+KILT kilt-import "github.com/robertkrimen/kilt" 3ff95df6033f176e5da9eef42a1b102ee25ac8e3 2014
+kilt.go, grave.go
+kiltGraveTrim func(target string) string
+kiltNow func(thing *Thing) (time.Time, error)
+xyzzy
+    `))
+}
+
+func TestRunFS(t *testing.T) {
+	Terst(t)
+
+	base, err := ioutil.TempDir("", "smuggol.")
+	Is(err, nil)
+	if err != nil {
+		FailNow()
+	}
+	defer os.RemoveAll(base)
+
+	src, host := filepath.Join(base, "kilt"), filepath.Join(base, "host")
+	os.MkdirAll(src, 0777)
+	os.MkdirAll(host, 0777)
+	ioutil.WriteFile(filepath.Join(src, "kilt.go"), []byte("package kilt\n\nfunc GraveTrim(target string) string { return target }\n"), 0666)
+	ioutil.WriteFile(filepath.Join(host, "host.go"), []byte("package host\n"), 0666)
+
+	defer func() {
+		flag_quiet = false
+	}()
+
+	err = RunFS("kilt-import", src, fstest.MapFS{
+		"kilt.go.tmpl":       &fstest.MapFile{Data: []byte("package {{ .HostPackage }}\n\nimport \"{{ .ImportPath }}\"\n")},
+		"nested/README.tmpl": &fstest.MapFile{Data: []byte("{{ upper .ImportPackage }}\n")},
+	}, []string{"-quiet", host})
+	Is(err, nil)
+	content, err := ioutil.ReadFile(filepath.Join(host, "kilt.go"))
+	Is(err, nil)
+	Like(string(content), `package host\s+import "\./kilt"`)
+	content, err = ioutil.ReadFile(filepath.Join(host, "nested", "README"))
+	Is(err, nil)
+	Is(string(content), "KILT\n")
+
+	// Run takes a map[string]string (or nil), as it always has
+	err = Run("kilt-import", src, map[string]string{
+		"kilt.go": "package {{ .HostPackage }} // {{ .ImportPackage }}\n",
+	}, []string{"-quiet", host})
+	Is(err, nil)
+	content, _ = ioutil.ReadFile(filepath.Join(host, "kilt.go"))
+	Like(string(content), `package host // kilt`)
+	Is(Run("kilt-import", src, nil, []string{"-quiet", host}), nil)
+}
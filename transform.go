@@ -0,0 +1,232 @@
+package smuggol
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"go/types"
+	"path"
+	"strconv"
+)
+
+// File is a single file of the import package, as parsed, for a Transformer to rewrite
+type File struct {
+	Name    string         // The name of the file (e.g. "kilt.go")
+	Package string         // The import path of the import package (e.g. "github.com/robertkrimen/kilt")
+	AST     *ast.File      // The file itself (rewrite this)
+	FileSet *token.FileSet // The positions of AST
+	Info    *types.Info    // The type information for the whole package, or nil if it does not type-check
+}
+
+// Transformer rewrites the files of an import package, between reading them and writing
+// them into the subordinate package. See Transform
+type Transformer interface {
+	Transform(file *File) error
+}
+
+// TransformerFunc is a function that is a Transformer
+type TransformerFunc func(file *File) error
+
+func (self TransformerFunc) Transform(file *File) error {
+	return self(file)
+}
+
+// Transform registers transformers to rewrite every file that is smuggled (by Main), in the
+// order given. A transformed file is printed (and so formatted) by go/format:
+//
+//      func main() {
+//          smuggol.Transform(
+//              smuggol.ReplaceImport("log", "example.com/xyzzy/log"),
+//              smuggol.StripInit(),
+//          )
+//          smuggol.Main("terst-import", "github.com/robertkrimen/terst", nil)
+//      }
+func Transform(transformers ...Transformer) {
+	mainTransformers = append(mainTransformers, transformers...)
+}
+
+// transform type-checks the files (in dir) of the package at importPath, and applies the transformers
+// to each, returning the result by name
+func transform(importPath, dir string, files []string, transformers []Transformer) (map[string][]byte, error) {
+	checked, err := typeCheck(importPath, dir, files)
+	if err != nil {
+		return nil, err
+	}
+	info := checked.info
+	if len(checked.errors) > 0 {
+		info = nil
+	}
+	result := map[string][]byte{}
+	for index, name := range files {
+		file := &File{
+			Name:    name,
+			Package: importPath,
+			AST:     checked.files[index],
+			FileSet: checked.fileSet,
+			Info:    info,
+		}
+		for _, transformer := range transformers {
+			err := transformer.Transform(file)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", path.Join(importPath, name), err)
+			}
+		}
+		var buffer bytes.Buffer
+		err := format.Node(&buffer, file.FileSet, file.AST)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path.Join(importPath, name), err)
+		}
+		result[name] = buffer.Bytes()
+	}
+	return result, nil
+}
+
+// Rename returns a Transformer that renames the package-level identifier from (a func, var, const,
+// or type) to to, everywhere it is used. It needs type information
+func Rename(from, to string) Transformer {
+	return TransformerFunc(func(file *File) error {
+		if file.Info == nil {
+			return fmt.Errorf("rename %s: %s does not type-check", from, file.Package)
+		}
+		// By way of the package scope, since the definition may have been renamed already (in another file)
+		var object types.Object
+		for _, defined := range file.Info.Defs {
+			if defined != nil && defined.Pkg() != nil {
+				object = defined.Pkg().Scope().Lookup(from)
+				break
+			}
+		}
+		if object == nil {
+			return nil
+		}
+		ast.Inspect(file.AST, func(node ast.Node) bool {
+			if ident, ok := node.(*ast.Ident); ok {
+				if file.Info.Defs[ident] == object || file.Info.Uses[ident] == object {
+					ident.Name = to
+				}
+			}
+			return true
+		})
+		return nil
+	})
+}
+
+// ReplaceImport returns a Transformer that replaces the import of from with to, which must
+// provide whatever is used of from. The import keeps its name, so nothing else changes:
+//
+//      import "log"    =>    import log "example.com/xyzzy/log"
+func ReplaceImport(from, to string) Transformer {
+	return TransformerFunc(func(file *File) error {
+		for _, spec := range file.AST.Imports {
+			importPath, _ := strconv.Unquote(spec.Path.Value)
+			if importPath != from {
+				continue
+			}
+			if spec.Name == nil {
+				name := path.Base(from)
+				if file.Info != nil {
+					if object, ok := file.Info.Implicits[spec].(*types.PkgName); ok {
+						name = object.Imported().Name()
+					}
+				}
+				if name != path.Base(to) {
+					spec.Name = &ast.Ident{Name: name, NamePos: spec.Path.Pos()}
+				}
+			}
+			spec.Path = &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(to), ValuePos: spec.Path.Pos()}
+		}
+		return nil
+	})
+}
+
+// StripInit returns a Transformer that removes every init function (and, with type information,
+// any import that is then unused)
+func StripInit() Transformer {
+	return TransformerFunc(func(file *File) error {
+		decls := []ast.Decl{}
+		for _, decl := range file.AST.Decls {
+			if decl, ok := decl.(*ast.FuncDecl); ok && decl.Recv == nil && decl.Name.Name == "init" {
+				removeComments(file.AST, decl.Pos(), decl.End())
+				if decl.Doc != nil {
+					removeComments(file.AST, decl.Doc.Pos(), decl.Doc.End())
+				}
+				continue
+			}
+			decls = append(decls, decl)
+		}
+		file.AST.Decls = decls
+		if file.Info != nil {
+			removeUnusedImports(file.AST, file.Info)
+		}
+		return nil
+	})
+}
+
+// removeComments removes the comments between start and end (which would otherwise be left
+// behind, somewhere, when what they belong to is removed)
+func removeComments(file *ast.File, start, end token.Pos) {
+	comments := []*ast.CommentGroup{}
+	for _, comment := range file.Comments {
+		if comment.Pos() >= start && comment.End() <= end {
+			continue
+		}
+		comments = append(comments, comment)
+	}
+	file.Comments = comments
+}
+
+// removeUnusedImports removes every (named, or implicitly named) import that is no longer used in file
+func removeUnusedImports(file *ast.File, info *types.Info) {
+	used := map[types.Object]bool{}
+	ast.Inspect(file, func(node ast.Node) bool {
+		if ident, ok := node.(*ast.Ident); ok {
+			if object, ok := info.Uses[ident].(*types.PkgName); ok {
+				used[object] = true
+			}
+		}
+		return true
+	})
+	unused := func(spec *ast.ImportSpec) bool {
+		object := info.Implicits[spec]
+		if spec.Name != nil {
+			if spec.Name.Name == "_" || spec.Name.Name == "." {
+				return false
+			}
+			object = info.Defs[spec.Name]
+		}
+		return object != nil && !used[object]
+	}
+
+	imports := []*ast.ImportSpec{}
+	for _, spec := range file.Imports {
+		if !unused(spec) {
+			imports = append(imports, spec)
+		}
+	}
+	file.Imports = imports
+	decls := []ast.Decl{}
+	for _, decl := range file.Decls {
+		if decl, ok := decl.(*ast.GenDecl); ok && decl.Tok == token.IMPORT {
+			specs := []ast.Spec{}
+			for _, spec := range decl.Specs {
+				if spec := spec.(*ast.ImportSpec); unused(spec) {
+					removeComments(file, spec.Pos(), spec.End())
+					if spec.Comment != nil {
+						removeComments(file, spec.Comment.Pos(), spec.Comment.End())
+					}
+					continue
+				}
+				specs = append(specs, spec)
+			}
+			if len(specs) == 0 {
+				removeComments(file, decl.Pos(), decl.End())
+				continue
+			}
+			decl.Specs = specs
+		}
+		decls = append(decls, decl)
+	}
+	file.Decls = decls
+}
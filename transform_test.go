@@ -0,0 +1,88 @@
+package smuggol
+
+import (
+	. "github.com/robertkrimen/smuggol/terst"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTransform(t *testing.T) {
+	Terst(t)
+
+	base, err := ioutil.TempDir("", "smuggol.")
+	Is(err, nil)
+	if err != nil {
+		FailNow()
+	}
+	defer os.RemoveAll(base)
+
+	ioutil.WriteFile(filepath.Join(base, "xyzzy.go"), []byte(`package xyzzy
+
+import (
+	"fmt"
+	"log"
+	"os" // For the environment
+)
+
+// init reads the environment
+func init() {
+	// Really
+	verbose = os.Getenv("XYZZY") != ""
+}
+
+var verbose = false
+
+func Xyzzy() string {
+	log.Print("xyzzy")
+	return fmt.Sprint(verbose)
+}
+`), 0666)
+	ioutil.WriteFile(filepath.Join(base, "nothing.go"), []byte("package xyzzy\n\nfunc Nothing() string { return Xyzzy() }\n"), 0666)
+	files := []string{"xyzzy.go", "nothing.go"}
+
+	result, err := transform("example.com/xyzzy", base, files, []Transformer{
+		Rename("Xyzzy", "Plugh"),
+		ReplaceImport("log", "example.com/xyzzy/log"),
+		StripInit(),
+	})
+	Is(err, nil)
+	Is(string(result["xyzzy.go"]), `package xyzzy
+
+import (
+	"example.com/xyzzy/log"
+	"fmt"
+)
+
+var verbose = false
+
+func Plugh() string {
+	log.Print("xyzzy")
+	return fmt.Sprint(verbose)
+}
+`)
+	Is(string(result["nothing.go"]), "package xyzzy\n\nfunc Nothing() string { return Plugh() }\n")
+
+	result, err = transform("example.com/xyzzy", base, files, []Transformer{
+		ReplaceImport("log", "example.com/xyzzy/logger"),
+	})
+	Is(err, nil)
+	Like(string(result["xyzzy.go"]), `\n\tlog "example.com/xyzzy/logger"\n`)
+
+	// A transformer that fails
+	_, err = transform("example.com/xyzzy", base, files, []Transformer{
+		TransformerFunc(func(file *File) error {
+			if file.Name == "nothing.go" {
+				return os.ErrInvalid
+			}
+			return nil
+		}),
+	})
+	Like(err, `^example.com/xyzzy/nothing.go: invalid argument$`)
+
+	// Without type information, there is no renaming
+	ioutil.WriteFile(filepath.Join(base, "broken.go"), []byte("package xyzzy\n\nvar broken int = \"\"\n"), 0666)
+	_, err = transform("example.com/xyzzy", base, append(files, "broken.go"), []Transformer{Rename("Xyzzy", "Plugh")})
+	Like(err, `rename Xyzzy: example.com/xyzzy does not type-check$`)
+}
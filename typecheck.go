@@ -0,0 +1,54 @@
+package smuggol
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+)
+
+// _checked is a parsed and type-checked package
+type _checked struct {
+	fileSet *token.FileSet
+	files   []*ast.File
+	pkg     *types.Package
+	info    *types.Info
+	errors  []types.Error
+}
+
+// typeCheck parses the given files in dir and type-checks them as the package
+// with the given import path, resolving any imports from source. Type errors do not stop the check, they are
+// collected in .errors (a syntax error, however, is returned as an error)
+func typeCheck(path, dir string, files []string) (*_checked, error) {
+	self := &_checked{
+		fileSet: token.NewFileSet(),
+		info: &types.Info{
+			Types:      map[ast.Expr]types.TypeAndValue{},
+			Defs:       map[*ast.Ident]types.Object{},
+			Uses:       map[*ast.Ident]types.Object{},
+			Selections: map[*ast.SelectorExpr]*types.Selection{},
+			Implicits:  map[ast.Node]types.Object{},
+		},
+	}
+
+	for _, name := range files {
+		file, err := parser.ParseFile(self.fileSet, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		self.files = append(self.files, file)
+	}
+
+	config := types.Config{
+		Importer: importer.ForCompiler(self.fileSet, "source", nil),
+		Error: func(err error) {
+			if err, ok := err.(types.Error); ok {
+				self.errors = append(self.errors, err)
+			}
+		},
+	}
+	self.pkg, _ = config.Check(path, self.fileSet, self.files, self.info)
+	return self, nil
+}
@@ -0,0 +1,60 @@
+package smuggol
+
+import (
+	"context"
+	"fmt"
+	"go/types"
+	"sort"
+)
+
+// verify type-checks the import package and then the host package (which may import
+// it), as they are now on disk. Every type error is reported with its position
+func verify(ctx context.Context, importPath, importDir string, hostPath, hostDir string) error {
+	problems := []types.Error{}
+
+	importPkg, err := buildImport(importDir)
+	if err != nil {
+		return err
+	}
+	checked, err := typeCheck(importPath, importDir, importPkg.GoFiles)
+	if err != nil {
+		return err
+	}
+	problems = append(problems, checked.errors...)
+
+	if hostDir != "" {
+		hostPkg, err := buildImport(hostDir)
+		if err != nil {
+			return err
+		}
+		checked, err := typeCheck(hostPath, hostDir, hostPkg.GoFiles)
+		if err != nil {
+			return err
+		}
+		problems = append(problems, checked.errors...)
+	}
+
+	if len(problems) == 0 {
+		outputOf(ctx).emit(_event{Action: "verify", Package: importPath})
+		return nil
+	}
+	sort.SliceStable(problems, func(i, j int) bool {
+		x, y := problems[i].Fset.Position(problems[i].Pos), problems[j].Fset.Position(problems[j].Pos)
+		if x.Filename != y.Filename {
+			return x.Filename < y.Filename
+		}
+		return x.Line < y.Line
+	})
+	errors := []string{}
+	for _, problem := range problems {
+		errors = append(errors, problem.Error())
+		if !flag_quiet {
+			fmt.Fprintf(outputOf(ctx).err(), "%s\n", problem)
+		}
+	}
+	outputOf(ctx).emit(_event{Action: "verify", Package: importPath, Errors: errors})
+	if len(problems) == 1 {
+		return fail(ErrVerify, fmt.Errorf("verify: 1 type error"))
+	}
+	return fail(ErrVerify, fmt.Errorf("verify: %d type errors", len(problems)))
+}
@@ -0,0 +1,174 @@
+package smuggol
+
+import (
+	"fmt"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// When directives import the same package at more than one version, each version is smuggled side
+// by side, into a subordinate package of its own, named for the version:
+//
+//      // legacy_test.go
+//      //smuggol:import github.com/robertkrimen/terst@v1.0.0
+//
+//      // terst_test.go
+//      //smuggol:import github.com/robertkrimen/terst@v2.0.0
+//
+// ...into terstv1 (package terstv1) and terstv2 (package terstv2). The file with the directive is
+// the consumer of that version: its import of the package (or of another version of it) is rewritten
+// to point at the copy, keeping the name it is used by:
+//
+//      import "github.com/robertkrimen/terst"    =>    import terst "example.com/host/terstv1"
+
+// versionSuffix returns what is appended to the name of a package (as an identifier) for a copy of it
+// at version: "v" and the major version, if major (and version is semantic), or else the whole version
+func versionSuffix(version string, major bool) string {
+	if parsed, ok := parseVersion(version); ok && major {
+		return fmt.Sprintf("v%d", parsed.number[0])
+	}
+	result := []byte{'_'}
+	for _, chr := range strings.ToLower(version) {
+		if (chr >= 'a' && chr <= 'z') || (chr >= '0' && chr <= '9') {
+			result = append(result, byte(chr))
+		} else if result[len(result)-1] != '_' {
+			result = append(result, '_')
+		}
+	}
+	return strings.TrimSuffix(string(result), "_")
+}
+
+// unversionedPath returns importPath without any major version suffix (e.g. /v2), which
+// is what the versions of a package have in common
+func unversionedPath(importPath string) string {
+	if index := strings.LastIndex(importPath, "/v"); index > 0 {
+		if major, err := strconv.Atoi(importPath[index+2:]); err == nil && major >= 2 {
+			return importPath[:index]
+		}
+	}
+	return importPath
+}
+
+// versionDirectives gives every (unnamed) directive that imports the same package as another, at
+// a version, a suffix (see versionSuffix): the major version, unless that is not enough to tell
+// them apart. Side-by-side versions must be in different files (their consumers)
+func versionDirectives(directives []directive) error {
+	groups := map[string][]int{}
+	for index, directive := range directives {
+		if directive.name != "" {
+			continue
+		}
+		importPath, _ := splitVersion(directive.path)
+		groups[unversionedPath(importPath)] = append(groups[unversionedPath(importPath)], index)
+	}
+	for _, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+		major := map[string]int{}
+		files := map[string]int{}
+		for _, index := range group {
+			directive := directives[index]
+			importPath, version := splitVersion(directive.path)
+			if version != "" {
+				major[versionSuffix(version, true)]++
+			}
+			if other, exists := files[directive.position.Filename]; exists {
+				return fmt.Errorf("%s: %s is also imported at %s (a version side by side with another needs a file of its own)", directive.position, unversionedPath(importPath), directives[other].position)
+			}
+			files[directive.position.Filename] = index
+		}
+		for _, index := range group {
+			_, version := splitVersion(directives[index].path)
+			if version == "" {
+				continue
+			}
+			suffix := versionSuffix(version, true)
+			if major[suffix] > 1 {
+				suffix = versionSuffix(version, false)
+			}
+			directives[index].suffix = suffix
+		}
+	}
+	return nil
+}
+
+// subordinateImportPath returns the import path of the subordinate package name, of host
+func subordinateImportPath(host *build.Package, name string) string {
+	if host.ImportPath == "." || host.ImportPath == "" {
+		return "./" + name
+	}
+	return path.Join(host.ImportPath, name)
+}
+
+// versions returns every entry that is a version of the package at importPath (see unversionedPath)
+func (self *_lock) versions(importPath string) []_lockImport {
+	result := []_lockImport{}
+	for _, entry := range self.Imports {
+		if unversionedPath(entry.Source) == unversionedPath(importPath) {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// rewriteImports points every import of one of from (import paths) in content (a Go source file) at to,
+// keeping name as the name the package is used by. Nothing else is touched
+func rewriteImports(content []byte, from map[string]bool, to, name string) ([]byte, error) {
+	fileSet := token.NewFileSet()
+	file, err := parser.ParseFile(fileSet, "", content, parser.ImportsOnly)
+	if err != nil {
+		return nil, err
+	}
+	result := content
+	// Last first, so that the offsets of the others stay put
+	for index := len(file.Imports) - 1; index >= 0; index-- {
+		spec := file.Imports[index]
+		importPath, _ := strconv.Unquote(spec.Path.Value)
+		if !from[importPath] || importPath == to {
+			continue
+		}
+		replacement := strconv.Quote(to)
+		if spec.Name == nil && name != path.Base(to) {
+			replacement = name + " " + replacement
+		}
+		start, end := fileSet.Position(spec.Path.Pos()).Offset, fileSet.Position(spec.Path.End()).Offset
+		result = append(append(append([]byte{}, result[:start]...), replacement...), result[end:]...)
+	}
+	return result, nil
+}
+
+// rewriteConsumers points the imports of source (or of any other version of it, as smuggled, see lock)
+// in each of consumers (files of the host package) at the subordinate package name
+func rewriteConsumers(journal *_journal, consumers []string, lock *_lock, host *build.Package, source *_source, name string) error {
+	from := map[string]bool{source.path: true}
+	for _, entry := range lock.versions(source.path) {
+		from[entry.Source] = true
+		if entry.Name != name {
+			from[subordinateImportPath(host, entry.Name)] = true
+		}
+	}
+	to := subordinateImportPath(host, name)
+	for _, consumer := range consumers {
+		content, err := os.ReadFile(consumer)
+		if err != nil {
+			return err
+		}
+		rewritten, err := rewriteImports(content, from, to, source.pkg.Name)
+		if err != nil {
+			return err
+		}
+		err = journal.writeFile(consumer, rewritten)
+		if err != nil {
+			return err
+		}
+		_, relativePath := relative(host.Dir, consumer)
+		journal.report(consumer, relativePath, "")
+	}
+	return nil
+}
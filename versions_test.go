@@ -0,0 +1,121 @@
+package smuggol
+
+import (
+	"archive/zip"
+	"bytes"
+	. "github.com/robertkrimen/smuggol/terst"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVersions(t *testing.T) {
+	Terst(t)
+
+	Is(versionSuffix("v2.3.1", true), "v2")
+	Is(versionSuffix("v2.3.1", false), "_v2_3_1")
+	Is(versionSuffix("0123abc", true), "_0123abc")
+	Is(unversionedPath("github.com/robertkrimen/terst/v2"), "github.com/robertkrimen/terst")
+	Is(unversionedPath("gopkg.in/yaml.v2"), "gopkg.in/yaml.v2")
+	Is(unversionedPath("example.com/v1"), "example.com/v1")
+
+	content, err := rewriteImports([]byte(kiltGraveTrim(`
+package host
+
+import (
+    "fmt" // Comment
+    "github.com/robertkrimen/terst"
+    . "example.com/host/terstv2"
+)
+    `)), map[string]bool{"github.com/robertkrimen/terst": true, "example.com/host/terstv2": true}, "example.com/host/terstv1", "terst")
+	Is(err, nil)
+	Is(string(content), kiltGraveTrim(`
+package host
+
+import (
+    "fmt" // Comment
+    terst "example.com/host/terstv1"
+    . "example.com/host/terstv1"
+)
+    `))
+
+	base, err := ioutil.TempDir("", "smuggol.")
+	Is(err, nil)
+	if err != nil {
+		FailNow()
+	}
+	defer os.RemoveAll(base)
+
+	// A proxy (on disk) with two (major) versions of example.com/xyzzy
+	dir := filepath.Join(base, "proxy", "example.com", "xyzzy", "@v")
+	os.MkdirAll(dir, 0777)
+	list := ""
+	for _, version := range []string{"v1.0.0", "v1.1.0", "v2.0.0"} {
+		var buffer bytes.Buffer
+		writer := zip.NewWriter(&buffer)
+		file, _ := writer.Create("example.com/xyzzy@" + version + "/xyzzy.go")
+		file.Write([]byte("package xyzzy\n\nconst Version = \"" + version + "\"\n"))
+		writer.Close()
+		list += version + "\n"
+		ioutil.WriteFile(filepath.Join(dir, version+".info"), []byte(`{"Version":"`+version+`"}`), 0666)
+		ioutil.WriteFile(filepath.Join(dir, version+".zip"), buffer.Bytes(), 0666)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "list"), []byte(list), 0666)
+
+	flag_proxy, flag_quiet = "file://"+filepath.ToSlash(filepath.Join(base, "proxy")), true
+	defer func() {
+		flag_proxy, flag_quiet = "", false
+	}()
+
+	host := filepath.Join(base, "host")
+	os.MkdirAll(host, 0777)
+	legacy := "package host\n\n//smuggol:import example.com/xyzzy@v1.0.0\n\nimport \"example.com/xyzzy\"\n\nvar legacy = xyzzy.Version\n"
+	ioutil.WriteFile(filepath.Join(host, "legacy.go"), []byte(legacy), 0666)
+	ioutil.WriteFile(filepath.Join(host, "host.go"), []byte("package host\n\n//smuggol:import example.com/xyzzy@v2.0.0\n\nimport (\n\t\"example.com/xyzzy\"\n)\n\nvar current = xyzzy.Version\n"), 0666)
+
+	Is(mainDirective(host, nil), nil)
+	for name, version := range map[string]string{"xyzzyv1": "v1.0.0", "xyzzyv2": "v2.0.0"} {
+		content, err := ioutil.ReadFile(filepath.Join(host, name, "xyzzy.go"))
+		Is(err, nil)
+		Like(string(content), `(?m)^package `+name+`\n\nconst Version = "`+version+`"`)
+	}
+	lock, err := readLock(host)
+	Is(err, nil)
+	Is(len(lock.versions("example.com/xyzzy")), 2)
+	Is(lock.get("xyzzyv1").Version, "v1.0.0")
+	Is(lock.get("xyzzyv2").Version, "v2.0.0")
+	content, _ = ioutil.ReadFile(filepath.Join(host, "legacy.go"))
+	Like(string(content), `\nimport xyzzy "\./xyzzyv1"\n`)
+	content, _ = ioutil.ReadFile(filepath.Join(host, "host.go"))
+	Like(string(content), `\n\txyzzy "\./xyzzyv2"\n`)
+	checked, err := typeCheck(".", host, []string{"host.go", "legacy.go"})
+	Is(err, nil)
+	Is(len(checked.errors), 0)
+
+	// The lock has an entry for each version (keyed by the subordinate package), and both survive another run
+	Is(mainDirective(host, nil), nil)
+	lock, err = readLock(host)
+	Is(err, nil)
+	Is(len(lock.Imports), 2)
+	Is(lock.get("xyzzyv1").Version, "v1.0.0")
+	Is(lock.get("xyzzyv2").Version, "v2.0.0")
+	Is(lock.get("xyzzyv1").Source, "example.com/xyzzy")
+	Is(lock.get("xyzzyv2").Source, "example.com/xyzzy")
+
+	// Another file importing v1.1.0 (the same major version as legacy.go) names the copy for the whole version
+	ioutil.WriteFile(filepath.Join(host, "xyzzy.go"), []byte("package host\n\n//smuggol:import example.com/xyzzy@v1.1.0\n"), 0666)
+	directives, err := scanDirectives(host)
+	Is(err, nil)
+	Is(len(directives), 3)
+	for _, directive := range directives {
+		if directive.path == "example.com/xyzzy@v1.1.0" {
+			Is(directive.suffix, "_v1_1_0")
+		}
+	}
+
+	// ...but not in the same file
+	ioutil.WriteFile(filepath.Join(host, "xyzzy.go"), []byte("package host\n\n//smuggol:import example.com/xyzzy@v1.1.0\n//smuggol:import example.com/xyzzy/v3@v3.0.0\n"), 0666)
+	_, err = scanDirectives(host)
+	Like(err, `xyzzy\.go:4:1: example\.com/xyzzy is also imported at .*xyzzy\.go:3:1`)
+}
@@ -0,0 +1,128 @@
+package smuggol
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// _watch polls the (local) directories of the import packages for changes to their .go files (see -watch)
+type _watch struct {
+	dirs     map[string]bool
+	interval time.Duration
+	mutex    sync.Mutex // For add, from concurrent imports
+}
+
+// _watchStamp is what a change to a file changes
+type _watchStamp struct {
+	modified time.Time
+	size     int64
+}
+
+func newWatch(interval time.Duration) *_watch {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	return &_watch{
+		dirs:     map[string]bool{},
+		interval: interval,
+	}
+}
+
+// add watches dir, the directory of an import package
+func (self *_watch) add(dir string) {
+	if self != nil {
+		self.mutex.Lock()
+		defer self.mutex.Unlock()
+		self.dirs[dir] = true
+	}
+}
+
+// snapshot stamps every .go file in the watched directories (by path)
+func (self *_watch) snapshot() map[string]_watchStamp {
+	result := map[string]_watchStamp{}
+	for dir := range self.dirs {
+		manifest, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue // Gone, for now
+		}
+		for _, file := range manifest {
+			if file.IsDir() || !strings.HasSuffix(file.Name(), ".go") {
+				continue
+			}
+			result[filepath.Join(dir, file.Name())] = _watchStamp{modified: file.ModTime(), size: file.Size()}
+		}
+	}
+	return result
+}
+
+// watchChanges returns the (sorted) paths that were added, changed, or removed between before and after
+func watchChanges(before, after map[string]_watchStamp) []string {
+	result := []string{}
+	for path, stamp := range after {
+		if previous, exists := before[path]; !exists || previous != stamp {
+			result = append(result, path)
+		}
+	}
+	for path := range before {
+		if _, exists := after[path]; !exists {
+			result = append(result, path)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// loop runs run (the import), and then again whenever a watched file changes, until interrupted.
+// A burst of changes is waited out (until nothing changes for an interval) before running again
+func (self *_watch) loop(run func() error) error {
+	report := func(err error) {
+		if err != nil {
+			emit(_event{Action: "error", Error: err.Error()})
+			fmt.Fprintf(os.Stderr, "%s: %s\n", mainName, err)
+		}
+	}
+
+	report(run())
+	if len(self.dirs) == 0 {
+		return fmt.Errorf("watch: nothing to watch (no import package is local)")
+	}
+	dirs := fmt.Sprintf("%d directories", len(self.dirs))
+	if len(self.dirs) == 1 {
+		dirs = "1 directory"
+	}
+	say("# watching %s (every %s)", dirs, self.interval)
+	last := self.snapshot()
+	for {
+		time.Sleep(self.interval)
+		current := self.snapshot()
+		if len(watchChanges(last, current)) == 0 {
+			continue
+		}
+		for {
+			time.Sleep(self.interval)
+			next := self.snapshot()
+			if len(watchChanges(current, next)) == 0 {
+				break
+			}
+			current = next
+		}
+
+		changes := watchChanges(last, current)
+		last = current
+		base, _ := os.Getwd()
+		for index, path := range changes {
+			if relative, err := filepath.Rel(base, path); err == nil && !strings.HasPrefix(relative, "..") {
+				changes[index] = relative
+			}
+		}
+		say("# %s: %s changed", time.Now().Format("15:04:05"), strings.Join(changes, ", "))
+		emit(_event{Action: "change", Files: changes})
+		report(run())
+	}
+}
@@ -0,0 +1,44 @@
+package smuggol
+
+import (
+	. "github.com/robertkrimen/smuggol/terst"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	Terst(t)
+
+	base, err := ioutil.TempDir("", "smuggol.")
+	Is(err, nil)
+	if err != nil {
+		FailNow()
+	}
+	defer os.RemoveAll(base)
+
+	xyzzy := filepath.Join(base, "xyzzy.go")
+	nothing := filepath.Join(base, "nothing.go")
+	ioutil.WriteFile(xyzzy, []byte("package xyzzy\n"), 0666)
+	ioutil.WriteFile(nothing, []byte("package xyzzy\n"), 0666)
+	ioutil.WriteFile(filepath.Join(base, "README"), []byte("xyzzy\n"), 0666)
+
+	var watch *_watch
+	watch.add(base) // Not watching
+
+	watch = newWatch(0)
+	Is(watch.interval, time.Second)
+	watch.add(base)
+	watch.add(filepath.Join(base, "missing"))
+	before := watch.snapshot()
+	Is(len(before), 2)
+	Is(watchChanges(before, watch.snapshot()), []string{})
+
+	ioutil.WriteFile(xyzzy, []byte("package xyzzy // Xyzzy\n"), 0666)
+	os.Remove(nothing)
+	ioutil.WriteFile(filepath.Join(base, "plugh.go"), []byte("package xyzzy\n"), 0666)
+	ioutil.WriteFile(filepath.Join(base, "README"), []byte("xyzzy, plugh\n"), 0666)
+	Is(watchChanges(before, watch.snapshot()), []string{nothing, filepath.Join(base, "plugh.go"), xyzzy})
+}